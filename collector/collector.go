@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -28,6 +29,11 @@ import (
 // Namespace defines the common namespace to be used by all metrics.
 const namespace = "node"
 
+// scrapeGeneration is incremented at the start of each scrape, so state shared
+// between collectors can be scoped to it. It's 0 outside of a scrape, e.g. when
+// a collector's Update is called directly.
+var scrapeGeneration atomic.Uint64
+
 var (
 	scrapeDurationDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
@@ -144,6 +150,7 @@ func (n NodeCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements the prometheus.Collector interface.
 func (n NodeCollector) Collect(ch chan<- prometheus.Metric) {
+	scrapeGeneration.Add(1)
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
@@ -0,0 +1,76 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+// errNoGPUScanner is returned by detectedGPUs when the gpu collector isn't
+// enabled.
+var errNoGPUScanner = errors.New("gpu collector is not enabled")
+
+// gpuInventory shares the GPUs detected by the gpu collector with the other
+// collectors, so sysfs is walked once per scrape.
+var gpuInventory gpuCache
+
+// gpuCache holds the result of a GPU scan for the scrape it was made in.
+type gpuCache struct {
+	mu sync.Mutex
+	// detect walks sysfs for GPUs, nil until the gpu collector is created.
+	detect     func() (gpuScan, error)
+	generation uint64
+	scan       gpuScan
+	err        error
+}
+
+// setDetect sets the function used to detect the GPUs and drops the cached
+// result.
+func (g *gpuCache) setDetect(detect func() (gpuScan, error)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.detect = detect
+	g.generation = 0
+	g.scan, g.err = gpuScan{}, nil
+}
+
+// get returns the GPUs detected in the scrape with the given generation,
+// detecting them on the first call of the scrape. Outside of a scrape, with
+// generation 0, they are always detected again. Callers get their own copy of
+// the GPU list and may modify it.
+func (g *gpuCache) get(generation uint64) (gpuScan, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.detect == nil {
+		return gpuScan{}, errNoGPUScanner
+	}
+	if generation == 0 || generation != g.generation {
+		g.scan, g.err = g.detect()
+		g.generation = generation
+	}
+	scan := g.scan
+	scan.gpus = slices.Clone(scan.gpus)
+	return scan, g.err
+}
+
+// detectedGPUs returns the GPUs on this host as detected by the gpu collector
+// in the current scrape, before any of its filters are applied.
+func detectedGPUs() ([]gpuDevice, error) {
+	scan, err := gpuInventory.get(scrapeGeneration.Load())
+	return scan.gpus, err
+}
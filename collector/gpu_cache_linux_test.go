@@ -0,0 +1,82 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGPUCache(t *testing.T) {
+	var cache gpuCache
+	if _, err := cache.get(1); !errors.Is(err, errNoGPUScanner) {
+		t.Fatalf("got error %v, want %v", err, errNoGPUScanner)
+	}
+
+	scans := 0
+	cache.setDetect(func() (gpuScan, error) {
+		scans++
+		return gpuScan{gpus: []gpuDevice{{busID: fmt.Sprintf("0000:%02x:00.0", scans)}}}, nil
+	})
+
+	// Within a scrape all callers get the same GPUs from a single scan.
+	first, err := cache.get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.gpus[0].model = "modified by the caller"
+	second, err := cache.get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scans != 1 {
+		t.Errorf("got %d scans within a scrape, want 1", scans)
+	}
+	if second.gpus[0].busID != "0000:01:00.0" || second.gpus[0].model != "" {
+		t.Errorf("got %+v from the cache, want the unmodified first scan", second.gpus[0])
+	}
+
+	// The next scrape scans again.
+	third, err := cache.get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scans != 2 || third.gpus[0].busID != "0000:02:00.0" {
+		t.Errorf("got %d scans and GPU %q in the next scrape, want 2 and 0000:02:00.0", scans, third.gpus[0].busID)
+	}
+
+	// Outside of a scrape nothing is cached.
+	_, _ = cache.get(0)
+	_, _ = cache.get(0)
+	if scans != 4 {
+		t.Errorf("got %d scans outside of a scrape, want 4", scans)
+	}
+}
+
+func TestDetectedGPUs(t *testing.T) {
+	newTestGPUCollector(t)
+	scrapeGeneration.Add(1)
+	t.Cleanup(func() { scrapeGeneration.Store(0) })
+
+	gpus, err := detectedGPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gpus) == 0 {
+		t.Fatal("no GPUs detected from the fixtures")
+	}
+}
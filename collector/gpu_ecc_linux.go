@@ -0,0 +1,75 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readAMDGPUECCEnabled reports whether ECC of the VRAM of the amdgpu card at
+// devicePath is enabled. amdgpu only creates ras/umc_err_count when RAS is
+// enabled for the memory controller, the ras directory is missing on cards
+// without RAS support or when it can't be read.
+func readAMDGPUECCEnabled(devicePath string) (bool, error) {
+	rasPath := filepath.Join(devicePath, "ras")
+	if _, err := os.Stat(rasPath); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(filepath.Join(rasPath, "umc_err_count"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// updateECC exposes whether ECC is enabled, from NVML for NVIDIA cards and
+// from the RAS nodes for amdgpu cards.
+func (c *gpuCollector) updateECC(ch chan<- prometheus.Metric, gpus []gpuDevice) {
+	eccEnabledDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "ecc_enabled"),
+		"Whether ECC is enabled for the GPU memory (0/1), -1 if unknown. state is current, or pending for the mode applied on the next reboot as reported by NVML.",
+		[]string{"gpu_id", "state"}, nil,
+	)
+	boolValue := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	for _, gpu := range gpus {
+		current, pending := -1.0, -1.0
+		switch {
+		case gpu.nvml != nil:
+			cur, pend, err := gpu.nvml.EccMode()
+			if err == nil {
+				current, pending = boolValue(cur), boolValue(pend)
+			} else if !errors.Is(err, errNVMLNotSupported) {
+				c.logger.Debug("Failed to get NVML ECC mode", "busID", gpu.busID, "error", err)
+			}
+			ch <- prometheus.MustNewConstMetric(eccEnabledDesc, prometheus.GaugeValue, pending, gpu.gpuID(), "pending")
+		case gpu.vendorID == vendorAMD:
+			if enabled, err := readAMDGPUECCEnabled(gpu.path); err == nil {
+				current = boolValue(enabled)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(eccEnabledDesc, prometheus.GaugeValue, current, gpu.gpuID(), "current")
+	}
+}
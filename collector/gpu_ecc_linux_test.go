@@ -0,0 +1,96 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testECCCollector runs the ECC part of the GPU collector against a fixed
+// set of GPUs.
+type testECCCollector struct {
+	c    *gpuCollector
+	gpus []gpuDevice
+}
+
+func (tc testECCCollector) Collect(ch chan<- prometheus.Metric) {
+	gpus := append([]gpuDevice(nil), tc.gpus...)
+	tc.c.attachNVML(gpus)
+	tc.c.updateECC(ch, gpus)
+}
+
+func (tc testECCCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(tc, ch)
+}
+
+func TestGPUNVMLECCEnabled(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			// ECC disabled, enabled on the next reboot.
+			"0000:17:00.0": {eccMode: &[2]bool{false, true}},
+			"0000:18:00.0": {eccMode: &[2]bool{true, true}},
+			// GeForce card without ECC.
+			"0000:19:00.0": {},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:18:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:19:00.0", vendorID: vendorNVIDIA},
+	}
+
+	expected := `# HELP node_gpu_ecc_enabled Whether ECC is enabled for the GPU memory (0/1), -1 if unknown. state is current, or pending for the mode applied on the next reboot as reported by NVML.
+# TYPE node_gpu_ecc_enabled gauge
+node_gpu_ecc_enabled{gpu_id="0000:17:00.0",state="current"} 0
+node_gpu_ecc_enabled{gpu_id="0000:17:00.0",state="pending"} 1
+node_gpu_ecc_enabled{gpu_id="0000:18:00.0",state="current"} 1
+node_gpu_ecc_enabled{gpu_id="0000:18:00.0",state="pending"} 1
+node_gpu_ecc_enabled{gpu_id="0000:19:00.0",state="current"} -1
+node_gpu_ecc_enabled{gpu_id="0000:19:00.0",state="pending"} -1
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testECCCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_ecc_enabled"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorAMDGPUECCEnabled(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// 0000:83:00.0 has ras/umc_err_count, 0000:84:00.0 only XGMI RAS and
+	// 0000:c1:00.0, bound to vfio-pci, no RAS nodes at all.
+	expected := `# HELP node_gpu_ecc_enabled Whether ECC is enabled for the GPU memory (0/1), -1 if unknown. state is current, or pending for the mode applied on the next reboot as reported by NVML.
+# TYPE node_gpu_ecc_enabled gauge
+node_gpu_ecc_enabled{gpu_id="0000:83:00.0",state="current"} 1
+node_gpu_ecc_enabled{gpu_id="0000:84:00.0",state="current"} 0
+node_gpu_ecc_enabled{gpu_id="0000:c1:00.0",state="current"} -1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_ecc_enabled"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// SMU 11 and later: "features high: 0x00000623 low: 0xb3cdaffb".
+	ppFeaturesHighLowRE = regexp.MustCompile(`^features high: 0x([0-9a-fA-F]+) low: 0x([0-9a-fA-F]+)$`)
+	// SMU 11 and later: "01. DPM_GFXCLK           ( 1) : enabled".
+	ppFeaturesBitRE = regexp.MustCompile(`^\d+\.\s+(\S+)\s+\(\s*(\d+)\)`)
+	// Vega10 and Vega12: "Current ppfeatures: 0x00000000000037cf".
+	ppFeaturesCurrentRE = regexp.MustCompile(`^Current ppfeatures: 0x([0-9a-fA-F]+)$`)
+	// Vega10 and Vega12: "DPM_GFXCLK    0x0000000000000002   Y".
+	ppFeaturesMaskRE = regexp.MustCompile(`^(\S+)\s+0x([0-9a-fA-F]+)\s+[YN]$`)
+)
+
+// parseAMDGPUPPFeatures parses the amdgpu pp_features file and returns the
+// lowercased names of the enabled SMU power features, e.g. dpm_gfxclk,
+// ds_socclk (deep sleep) or gfx_per_cu_cg (clock gating).
+//
+// The bit positions of the features differ between SMU firmware versions, so
+// rather than a fixed table the file's own listing is used: each line names a
+// feature and its bit, and the feature is enabled if that bit is set in the
+// mask of the header line.
+func parseAMDGPUPPFeatures(data string) ([]string, error) {
+	var (
+		mask     uint64
+		haveMask bool
+		features []string
+	)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if m := ppFeaturesHighLowRE.FindStringSubmatch(line); m != nil {
+			high, err := strconv.ParseUint(m[1], 16, 32)
+			if err != nil {
+				return nil, err
+			}
+			low, err := strconv.ParseUint(m[2], 16, 32)
+			if err != nil {
+				return nil, err
+			}
+			mask, haveMask = high<<32|low, true
+			continue
+		}
+		if m := ppFeaturesCurrentRE.FindStringSubmatch(line); m != nil {
+			v, err := strconv.ParseUint(m[1], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+			mask, haveMask = v, true
+			continue
+		}
+		if !haveMask {
+			continue
+		}
+		if m := ppFeaturesBitRE.FindStringSubmatch(line); m != nil {
+			bit, err := strconv.ParseUint(m[2], 10, 6)
+			if err != nil {
+				return nil, fmt.Errorf("invalid feature bit in %q: %w", line, err)
+			}
+			if mask&(1<<bit) != 0 {
+				features = append(features, strings.ToLower(m[1]))
+			}
+			continue
+		}
+		if m := ppFeaturesMaskRE.FindStringSubmatch(line); m != nil {
+			bits, err := strconv.ParseUint(m[2], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid feature mask in %q: %w", line, err)
+			}
+			if bits != 0 && mask&bits == bits {
+				features = append(features, strings.ToLower(m[1]))
+			}
+		}
+	}
+	if !haveMask {
+		return nil, errors.New("no feature mask found")
+	}
+	return features, nil
+}
+
+// updatePowerFeatures exposes the enabled SMU power features of amdgpu cards
+// with a pp_features file.
+func (c *gpuCollector) updatePowerFeatures(ch chan<- prometheus.Metric, gpus []gpuDevice) {
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "power_feature_enabled"),
+		"Power management feature enabled in the SMU firmware of the GPU, from amdgpu pp_features.",
+		[]string{"gpu_id", "feature"}, nil,
+	)
+	for _, gpu := range gpus {
+		data, err := os.ReadFile(filepath.Join(gpu.path, "pp_features"))
+		if err != nil {
+			continue
+		}
+		features, err := parseAMDGPUPPFeatures(string(data))
+		if err != nil {
+			c.logger.Debug("Failed to parse pp_features", "busID", gpu.busID, "error", err)
+			continue
+		}
+		for _, feature := range features {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, gpu.gpuID(), feature)
+		}
+	}
+}
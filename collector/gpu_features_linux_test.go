@@ -0,0 +1,85 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseAMDGPUPPFeatures(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "smu11",
+			// Bits 0, 1, 33 and 35 set.
+			data: `features high: 0x0000000a low: 0x00000003
+00. DPM_PREFETCHER       ( 0) : enabled
+01. DPM_GFXCLK           ( 1) : enabled
+02. DPM_UCLK             ( 2) : disabled
+33. GFX_PER_CU_CG        (33) : enabled
+34. GFX_PG               (34) : disabled
+35. GFXOFF               (35) : enabled
+`,
+			want: []string{"dpm_prefetcher", "dpm_gfxclk", "gfx_per_cu_cg", "gfxoff"},
+		},
+		{
+			name: "vega10",
+			data: `Current ppfeatures: 0x0000000000000005
+FEATURES            BITMASK               ENABLEMENT
+DPM_PREFETCHER      0x0000000000000001     Y
+GFXCLK_DPM          0x0000000000000002     N
+UCLK_DPM            0x0000000000000004     Y
+`,
+			want: []string{"dpm_prefetcher", "uclk_dpm"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAMDGPUPPFeatures(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := parseAMDGPUPPFeatures("00. DPM_PREFETCHER ( 0) : enabled\n"); err == nil {
+		t.Error("expected error without feature mask")
+	}
+}
+
+func TestGPUCollectorPowerFeatures(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// Only 0000:83:00.0 has a pp_features file.
+	expected := `# HELP node_gpu_power_feature_enabled Power management feature enabled in the SMU firmware of the GPU, from amdgpu pp_features.
+# TYPE node_gpu_power_feature_enabled gauge
+node_gpu_power_feature_enabled{feature="dpm_gfxclk",gpu_id="0000:83:00.0"} 1
+node_gpu_power_feature_enabled{feature="dpm_prefetcher",gpu_id="0000:83:00.0"} 1
+node_gpu_power_feature_enabled{feature="gfxoff",gpu_id="0000:83:00.0"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_power_feature_enabled"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/common/model"
+	"go.yaml.in/yaml/v2"
+)
+
+var (
+	gpuLabelFile = kingpin.Flag("collector.gpu.label-file", "YAML or JSON file mapping GPU PCI addresses to extra node_gpu_info labels.").String()
+
+	pciAddressRE = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+)
+
+// gpuInfoLabelNames are the labels node_gpu_info always carries, operator
+// provided labels must not collide with them.
+var gpuInfoLabelNames = []string{"gpu_id", "vendor", "model", "vendor_id", "device_id", "iommu_group", "minor", "class_name", "subsystem_vendor_id", "subsystem_device_id", "unique_id"}
+
+// gpuFingerprintLabel is added to node_gpu_info by --collector.gpu.fingerprint.
+const gpuFingerprintLabel = "fingerprint"
+
+// gpuNameLabelNames are added to node_gpu_info by --collector.gpu.names.
+var gpuNameLabelNames = []string{"subsystem_vendor_name", "subsystem_device_name"}
+
+// gpuDCGMLabelNames are added to node_gpu_info by --collector.gpu.dcgm-labels,
+// named like the labels of NVIDIA's DCGM exporter.
+var gpuDCGMLabelNames = []string{"UUID", "device", "modelName", "GPU_I_ID"}
+
+// gpuDriverLabel is added to node_gpu_info by --collector.gpu.include-unbound.
+const gpuDriverLabel = "driver"
+
+// gpuIDFallbackLabel is added to node_gpu_info by a --collector.gpu.id-label
+// other than bus.
+const gpuIDFallbackLabel = "id_fallback"
+
+// gpuLabelFileContent is the on-disk format of --collector.gpu.label-file.
+// All label keys must be declared up front so the label set of node_gpu_info
+// doesn't depend on which GPUs are present:
+//
+//	labels: [team, owner]
+//	gpus:
+//	  "0000:83:00.0": {team: ml, owner: alice}
+type gpuLabelFileContent struct {
+	Labels []string                     `yaml:"labels"`
+	GPUs   map[string]map[string]string `yaml:"gpus"`
+}
+
+// gpuLabels holds the operator provided labels for node_gpu_info.
+type gpuLabels struct {
+	names  []string
+	values map[string]map[string]string
+}
+
+// loadGPULabels reads and validates the label file at path.
+func loadGPULabels(path string) (*gpuLabels, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGPULabels(data)
+}
+
+// parseGPULabels parses a label file. JSON is accepted as it's a subset of YAML.
+func parseGPULabels(data []byte) (*gpuLabels, error) {
+	var content gpuLabelFileContent
+	if err := yaml.UnmarshalStrict(data, &content); err != nil {
+		return nil, err
+	}
+
+	declared := make(map[string]bool, len(content.Labels))
+	for _, name := range content.Labels {
+		if !model.LabelName(name).IsValidLegacy() {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if name == gpuFingerprintLabel || name == gpuDriverLabel || name == gpuIDFallbackLabel || slices.Contains(gpuInfoLabelNames, name) || slices.Contains(gpuNameLabelNames, name) || slices.Contains(gpuDCGMLabelNames, name) {
+			return nil, fmt.Errorf("label %q collides with a node_gpu_info label", name)
+		}
+		if declared[name] {
+			return nil, fmt.Errorf("label %q declared twice", name)
+		}
+		declared[name] = true
+	}
+
+	for busID, labels := range content.GPUs {
+		if !pciAddressRE.MatchString(busID) {
+			return nil, fmt.Errorf("invalid PCI address %q", busID)
+		}
+		for name := range labels {
+			if !declared[name] {
+				return nil, fmt.Errorf("label %q of GPU %s is not declared in labels", name, busID)
+			}
+		}
+	}
+
+	return &gpuLabels{
+		names:  content.Labels,
+		values: content.GPUs,
+	}, nil
+}
+
+// valuesFor returns the label values of the GPU at busID in the order of
+// the declared names, empty for GPUs missing from the file.
+func (l *gpuLabels) valuesFor(busID string) []string {
+	values := make([]string, len(l.names))
+	for i, name := range l.names {
+		values[i] = l.values[busID][name]
+	}
+	return values
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGPUCollectorLabelFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labels.yml")
+	content := `labels: [team, owner]
+gpus:
+  "0000:83:00.0": {team: ml, owner: alice}
+  "0000:84:00.0": {team: infra}
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	*gpuLabelFile = path
+	t.Cleanup(func() { *gpuLabelFile = "" })
+
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",owner="alice",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",team="ml",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:84:00.0",iommu_group="40",minor="1",model="AMD Instinct MI250X/MI250",owner="",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",team="infra",unique_id="8f2c3a1d5e7b9147",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740f",gpu_id="0000:c1:00.0",iommu_group="62",minor="",model="AMD Instinct MI210",owner="",subsystem_device_id="0x0c34",subsystem_vendor_id="0x1002",team="",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseGPULabels(t *testing.T) {
+	// JSON is read by the same YAML parser.
+	labels, err := parseGPULabels([]byte(`{"labels": ["team"], "gpus": {"0000:83:00.0": {"team": "ml"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := labels.valuesFor("0000:83:00.0"); len(got) != 1 || got[0] != "ml" {
+		t.Errorf("unexpected values for listed GPU: %q", got)
+	}
+	if got := labels.valuesFor("0000:84:00.0"); len(got) != 1 || got[0] != "" {
+		t.Errorf("unexpected values for unlisted GPU: %q", got)
+	}
+
+	for name, content := range map[string]string{
+		"undeclared label": `{"labels": ["team"], "gpus": {"0000:83:00.0": {"owner": "alice"}}}`,
+		"builtin label":    `{"labels": ["model"]}`,
+		"fingerprint":      `{"labels": ["fingerprint"]}`,
+		"invalid label":    `{"labels": ["team-name"]}`,
+		"duplicate label":  `{"labels": ["team", "team"]}`,
+		"invalid address":  `{"labels": ["team"], "gpus": {"83:00.0": {"team": "ml"}}}`,
+		"unknown field":    `{"labels": ["team"], "devices": {}}`,
+	} {
+		if _, err := parseGPULabels([]byte(content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -16,12 +16,42 @@
 package collector
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	gpuSysfsPath      = kingpin.Flag("collector.gpu.sysfs-path", "Directory to scan for GPU devices, relative to --path.sysfs unless absolute.").Default("bus/pci/devices").String()
+	gpuFingerprint    = kingpin.Flag("collector.gpu.fingerprint", "Add a fingerprint label identifying the physical card to node_gpu_info.").Default("false").Bool()
+	gpuIncludeUnbound = kingpin.Flag("collector.gpu.include-unbound", "Also expose GPUs without a GPU driver bound, e.g. after a driver crash, with an empty driver label on node_gpu_info.").Default("false").Bool()
+	gpuPerCard        = kingpin.Flag("collector.gpu.per-card", "Expose one GPU per physical card: display functions sharing the PCI domain:bus:device are collapsed into the one with the lowest function number.").Default("false").Bool()
+	gpuNames          = kingpin.Flag("collector.gpu.names", "Resolve the PCI class and subsystem of GPUs to names on node_gpu_info, e.g. class_name 3D controller. The PCI ID database is configured by the --collector.pcidevice.ids* flags and shared with the pcidevice collector.").Default("false").Bool()
+	gpuRequireRender  = kingpin.Flag("collector.gpu.require-render-node", "Exclude GPUs without a DRM render node (renderD*), e.g. display-only adapters that can't run compute.").Default("false").Bool()
+	gpuMetricPrefix   = kingpin.Flag("collector.gpu.metric-prefix", "Subsystem of the GPU metric names, e.g. mygpu for node_mygpu_info, to avoid collisions with other exporters.").Default("gpu").String()
+	gpuUtilSamples    = kingpin.Flag("collector.gpu.util-samples", "Number of gpu_busy_percent reads averaged into node_gpu_utilization_ratio, spread over up to 200ms of the scrape. 1 reads it once.").Default("1").Int()
+	gpuMaxModels      = kingpin.Flag("collector.gpu.max-models", "Maximum number of distinct model labels on node_gpu_cards_total, the least common models are counted as model \"other\". 0 means unlimited.").Default("0").Int()
+	gpuIDLabel        = kingpin.Flag("collector.gpu.id-label", "Value of the gpu_id label: bus for the PCI address, serial for the board serial number or uuid for the NVML UUID or amdgpu unique_id, which follow the card when it's moved to another slot. GPUs without the selected ID fall back to the PCI address and get the id_fallback=\"bus\" label on node_gpu_info.").Default(gpuIDLabelBus).Enum(gpuIDLabelBus, gpuIDLabelSerial, gpuIDLabelUUID)
+	gpuValidate       = kingpin.Flag("collector.gpu.validate", "Log each display controller found at startup at info level with its vendor, bound driver and why it's skipped, to debug missing GPU metrics.").Default("false").Bool()
+	gpuInfoOnly       = kingpin.Flag("collector.gpu.info-only", "Only expose node_gpu_info and node_gpu_cards_total, e.g. for inventory, skipping all other GPU metrics.").Default("false").Bool()
+	gpuTopN           = kingpin.Flag("collector.gpu.top-n", "Only expose the health metrics of the N GPUs ranking highest by --collector.gpu.top-n.by, node_gpu_info and the node level metrics still cover all GPUs. 0 exposes all GPUs.").Default("0").Int()
+	gpuTopNBy         = kingpin.Flag("collector.gpu.top-n.by", "Ranking of --collector.gpu.top-n: temperature for the hottest sensor of the GPU or utilization for amdgpu gpu_busy_percent. GPUs without a reading rank last.").Default(gpuTopNByTemperature).Enum(gpuTopNByTemperature, gpuTopNByUtilization)
+	gpuDCGMLabels     = kingpin.Flag("collector.gpu.dcgm-labels", "Add the UUID, device, modelName and GPU_I_ID labels of NVIDIA's DCGM exporter to node_gpu_info, so DCGM dashboards work unchanged. The native labels are kept.").Default("false").Bool()
+	gpuMinVRAMBytes   = kingpin.Flag("collector.gpu.min-vram-bytes", "Exclude GPUs with less VRAM than this, e.g. display adapters. GPUs with unknown VRAM are always included.").Default("0").Uint64()
 )
 
 // GPU vendor IDs (whitelist)
@@ -31,6 +61,27 @@ const (
 	vendorIntel  = "0x8086"
 )
 
+// The values of --collector.gpu.id-label.
+const (
+	gpuIDLabelBus    = "bus"
+	gpuIDLabelSerial = "serial"
+	gpuIDLabelUUID   = "uuid"
+)
+
+// The values of --collector.gpu.top-n.by.
+const (
+	gpuTopNByTemperature = "temperature"
+	gpuTopNByUtilization = "utilization"
+)
+
+// gpuUtilSampleInterval is the delay between two gpu_busy_percent reads with
+// --collector.gpu.util-samples, gpuUtilMaxSamples keeps the sampling of a
+// scrape under 200ms.
+const (
+	gpuUtilSampleInterval = 20 * time.Millisecond
+	gpuUtilMaxSamples     = 11
+)
+
 // Known BMC/Management graphics (blacklist)
 var bmcVendors = map[string]bool{
 	"0x1a03": true, // ASPEED
@@ -168,8 +219,134 @@ var nvidiaProducts = map[string]string{
 	"0x26b2": "NVIDIA RTX 5000 Ada",
 }
 
+// AMD device ID to product name mapping (common GPUs)
+var amdProducts = map[string]string{
+	// Instinct
+	"0x66a1": "AMD Instinct MI50",
+	"0x738c": "AMD Instinct MI100",
+	"0x7408": "AMD Instinct MI250X",
+	"0x740c": "AMD Instinct MI250X/MI250",
+	"0x740f": "AMD Instinct MI210",
+	"0x74a0": "AMD Instinct MI300A",
+	"0x74a1": "AMD Instinct MI300X",
+
+	// Radeon Pro
+	"0x7448": "AMD Radeon Pro W7900",
+
+	// Radeon RX
+	"0x73bf": "AMD Radeon RX 6800/6800 XT/6900 XT",
+	"0x73df": "AMD Radeon RX 6700/6700 XT/6750 XT",
+	"0x744c": "AMD Radeon RX 7900 XT/7900 XTX",
+}
+
+// Intel device ID to product name mapping (common GPUs)
+var intelProducts = map[string]string{
+	// Data Center
+	"0x0bd5": "Intel Data Center GPU Max 1550",
+	"0x0bda": "Intel Data Center GPU Max 1100",
+	"0x56c0": "Intel Data Center GPU Flex 170",
+	"0x56c1": "Intel Data Center GPU Flex 140",
+
+	// Arc
+	"0x56a0": "Intel Arc A770",
+	"0x56a1": "Intel Arc A750",
+	"0xe20b": "Intel Arc B580",
+	"0xe20c": "Intel Arc B570",
+}
+
 type gpuCollector struct {
-	logger *slog.Logger
+	logger    *slog.Logger
+	sysfsPath string
+	// subsystem is the --collector.gpu.metric-prefix of the metric names.
+	subsystem string
+	nvml      nvmlLibrary
+	labels    *gpuLabels
+	minVRAM   uint64
+	// dcgmLabels adds the DCGM exporter labels to node_gpu_info.
+	dcgmLabels bool
+	// fingerprint adds the fingerprint label to node_gpu_info.
+	fingerprint bool
+	// perCard collapses the display functions of a card into one GPU.
+	perCard bool
+	// requireRenderNode drops the GPUs without a DRM render node.
+	requireRenderNode bool
+	// includeUnbound keeps GPUs without a driver bound and adds the driver
+	// label to node_gpu_info.
+	includeUnbound bool
+	// thermalHistory tracks thermal throttling to detect flapping, nil if
+	// disabled.
+	thermalHistory *gpuThrottleHistory
+	// utilSamples is the number of gpu_busy_percent reads averaged per
+	// scrape, sleep waits between them.
+	utilSamples int
+	sleep       func(time.Duration)
+	// maxModels caps the models of node_gpu_cards_total, 0 if unlimited.
+	maxModels int
+	// infoOnly limits the metrics to node_gpu_info and node_gpu_cards_total.
+	infoOnly bool
+	// topN limits the health metrics to the GPUs ranking highest by topNBy,
+	// 0 for all GPUs.
+	topN   int
+	topNBy string
+	// ambientSensor is the hwmon file of the ambient temperature, empty if
+	// not set.
+	ambientSensor string
+	// memClockThrottleRatio and memClockThrottleMinUtil are the thresholds
+	// of node_gpu_memory_clock_throttled.
+	memClockThrottleRatio   float64
+	memClockThrottleMinUtil float64
+	// idLabel is the --collector.gpu.id-label source of the gpu_id label,
+	// empty for bus.
+	idLabel string
+	// pciProvider resolves class and subsystem names, nil without
+	// --collector.gpu.names.
+	pciProvider *pciIDProvider
+	// source is the --collector.gpu.source of the temperature, power and
+	// clock metrics.
+	source string
+
+	// nvmlAccountingMaxProcesses caps the processes exposed per GPU from
+	// NVML accounting, 0 if accounting metrics are disabled.
+	nvmlAccountingMaxProcesses int
+}
+
+// gpuDevice describes a GPU detected on the PCI bus.
+type gpuDevice struct {
+	busID    string
+	path     string
+	vendorID string
+	deviceID string
+	vendor   string
+	model    string
+	// subsystemVendorID and subsystemDeviceID identify the board partner
+	// variant, empty if they can't be read.
+	subsystemVendorID string
+	subsystemDeviceID string
+	// class is the PCI class, e.g. 0x030200, empty if it can't be read.
+	class string
+	// iommuGroup is the IOMMU group number, "-1" if not in a group.
+	iommuGroup string
+	// minor is the minor number of the DRM card node, empty without one.
+	minor string
+	// driver is the bound GPU driver, empty if there is none.
+	driver string
+	// driverVersion is the version of the driver's kernel module, empty if
+	// the module doesn't report one, e.g. in-tree drivers.
+	driverVersion string
+	// uniqueID is the amdgpu unique_id, a serial number that follows the
+	// card across slots, empty for other drivers or cards without one.
+	uniqueID string
+
+	// memoryTotal is the VRAM size in bytes, 0 if unknown.
+	memoryTotal uint64
+
+	// id is the gpu_id label value selected by --collector.gpu.id-label,
+	// empty for the bus ID. idFallback is set if the selected ID isn't
+	// available and the bus ID is used instead.
+	id         string
+	idFallback bool
+
+	nvml nvmlDevice
 }
 
 func init() {
@@ -178,9 +355,78 @@ func init() {
 
 // NewGPUCollector returns a new Collector exposing GPU stats.
 func NewGPUCollector(logger *slog.Logger) (Collector, error) {
-	return &gpuCollector{
-		logger: logger,
-	}, nil
+	c := &gpuCollector{
+		logger:                  logger,
+		sysfsPath:               *gpuSysfsPath,
+		subsystem:               *gpuMetricPrefix,
+		minVRAM:                 *gpuMinVRAMBytes,
+		fingerprint:             *gpuFingerprint,
+		dcgmLabels:              *gpuDCGMLabels,
+		includeUnbound:          *gpuIncludeUnbound,
+		perCard:                 *gpuPerCard,
+		requireRenderNode:       *gpuRequireRender,
+		source:                  *gpuSource,
+		maxModels:               *gpuMaxModels,
+		infoOnly:                *gpuInfoOnly,
+		ambientSensor:           *gpuAmbientSensor,
+		memClockThrottleRatio:   *gpuMemClockThrottleRatio,
+		memClockThrottleMinUtil: *gpuMemClockThrottleMinUtil,
+		topN:                    *gpuTopN,
+		topNBy:                  *gpuTopNBy,
+		utilSamples:             min(max(*gpuUtilSamples, 1), gpuUtilMaxSamples),
+		sleep:                   time.Sleep,
+	}
+	if *gpuIDLabel != gpuIDLabelBus {
+		c.idLabel = *gpuIDLabel
+	}
+	// The prefix ends up between underscores, any label name is a valid
+	// metric name component.
+	if !model.LabelName(c.subsystem).IsValidLegacy() {
+		return nil, fmt.Errorf("invalid GPU metric prefix %q", c.subsystem)
+	}
+	if *gpuThermalFlappingWindow > 0 {
+		c.thermalHistory = newGPUThrottleHistory(*gpuThermalFlappingWindow, *gpuThermalFlappingThreshold)
+	}
+	if !filepath.IsAbs(c.sysfsPath) {
+		c.sysfsPath = sysFilePath(c.sysfsPath)
+	}
+	if c.ambientSensor != "" && !filepath.IsAbs(c.ambientSensor) {
+		c.ambientSensor = sysFilePath(c.ambientSensor)
+	}
+	if _, err := os.Stat(c.sysfsPath); err != nil {
+		logger.Warn("GPU sysfs path is not accessible", "path", c.sysfsPath, "error", err)
+	}
+
+	if *gpuNames {
+		c.pciProvider = sharedPCIIDProvider(logger)
+	}
+
+	if *gpuLabelFile != "" {
+		labels, err := loadGPULabels(*gpuLabelFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load GPU label file %q: %w", *gpuLabelFile, err)
+		}
+		c.labels = labels
+	}
+
+	if *gpuNVML {
+		lib, err := newNVMLLibrary()
+		if err != nil {
+			logger.Warn("NVML is not available, disabling NVML metrics", "error", err)
+		} else {
+			c.nvml = lib
+		}
+		if *gpuNVMLAccounting {
+			c.nvmlAccountingMaxProcesses = *gpuNVMLAccountingMaxProcesses
+		}
+	}
+
+	if *gpuValidate {
+		c.logValidation()
+	}
+
+	gpuInventory.setDetect(c.detect)
+	return c, nil
 }
 
 // readSysfsFile reads a file from sysfs and returns trimmed content
@@ -192,145 +438,1259 @@ func readSysfsFile(path string) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-// isGPUDriverLoaded checks if a GPU driver (not vfio) is bound to the device
-func isGPUDriverLoaded(devicePath string) bool {
+// readGPUDriver returns the GPU driver bound to the device at devicePath, or
+// "" if no driver or a driver other than a GPU or passthrough one is bound.
+func readGPUDriver(devicePath string) string {
 	driverLink := filepath.Join(devicePath, "driver")
 	target, err := os.Readlink(driverLink)
 	if err != nil {
-		return false
+		return ""
 	}
 	driverName := filepath.Base(target)
 	// Valid GPU drivers: native drivers + vfio-pci for passthrough
 	validDrivers := []string{"nvidia", "nouveau", "amdgpu", "radeon", "i915", "xe", "vfio-pci"}
 	for _, d := range validDrivers {
 		if driverName == d {
-			return true
+			return driverName
+		}
+	}
+	return ""
+}
+
+// readDRMCardMinor returns the minor number of the DRM card node of the GPU
+// at devicePath, or "" if no driver registered one.
+func readDRMCardMinor(devicePath string) string {
+	cards, err := filepath.Glob(filepath.Join(devicePath, "drm", "card[0-9]*"))
+	if err != nil || len(cards) == 0 {
+		return ""
+	}
+	card := cards[0]
+	// dev holds "<major>:<minor>", fall back to the N of cardN without it.
+	if dev, err := readSysfsFile(filepath.Join(card, "dev")); err == nil {
+		if _, minor, ok := strings.Cut(dev, ":"); ok {
+			return minor
+		}
+	}
+	return strings.TrimPrefix(filepath.Base(card), "card")
+}
+
+// hasDRMRenderNode reports whether the GPU at devicePath has a DRM render
+// node, the renderD* node compute and offscreen rendering go through.
+func hasDRMRenderNode(devicePath string) bool {
+	nodes, err := filepath.Glob(filepath.Join(devicePath, "drm", "renderD[0-9]*"))
+	return err == nil && len(nodes) > 0
+}
+
+// readAMDGPUFirmwareVersions returns the versions of the firmware loaded by
+// amdgpu keyed by component, e.g. smc, sdma or vcn, read from the
+// <component>_fw_version files of the fw_version directory.
+func readAMDGPUFirmwareVersions(devicePath string) map[string]string {
+	files, err := filepath.Glob(filepath.Join(devicePath, "fw_version", "*_fw_version"))
+	if err != nil {
+		return nil
+	}
+	versions := make(map[string]string)
+	for _, file := range files {
+		version, err := readSysfsFile(file)
+		if err != nil || version == "" {
+			continue
+		}
+		versions[strings.TrimSuffix(filepath.Base(file), "_fw_version")] = version
+	}
+	return versions
+}
+
+// readDRMConnectors returns the number of display connectors of the GPU at
+// devicePath, read from the cardN-<connector> directories of its DRM card
+// node, and how many of them have a display connected. ok is false if the GPU
+// has no DRM card node.
+func readDRMConnectors(devicePath string) (connected, total int, ok bool) {
+	cards, err := filepath.Glob(filepath.Join(devicePath, "drm", "card[0-9]*"))
+	if err != nil || len(cards) == 0 {
+		return 0, 0, false
+	}
+	connectors, err := filepath.Glob(filepath.Join(cards[0], filepath.Base(cards[0])+"-*"))
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, connector := range connectors {
+		status, err := readSysfsFile(filepath.Join(connector, "status"))
+		if err != nil {
+			continue
+		}
+		total++
+		if status == "connected" {
+			connected++
+		}
+	}
+	return connected, total, true
+}
+
+// readGPUPowerRails returns the power in watts of each hwmon power rail of
+// the GPU at devicePath, keyed by the rail index. powerN_average is preferred
+// over powerN_input when a driver provides both.
+func readGPUPowerRails(devicePath string) map[string]float64 {
+	files, err := filepath.Glob(filepath.Join(devicePath, "hwmon", "hwmon*", "power*_*"))
+	if err != nil {
+		return nil
+	}
+	rails := make(map[string]float64)
+	averaged := make(map[string]bool)
+	for _, file := range files {
+		rail, kind, ok := strings.Cut(strings.TrimPrefix(filepath.Base(file), "power"), "_")
+		if !ok || (kind != "average" && kind != "input") {
+			continue
+		}
+		if _, err := strconv.Atoi(rail); err != nil {
+			continue
+		}
+		if kind == "input" && averaged[rail] {
+			continue
+		}
+		microwatts, err := readUintFromFile(file)
+		if err != nil {
+			continue
+		}
+		rails[rail] = float64(microwatts) / 1e6
+		averaged[rail] = kind == "average"
+	}
+	return rails
+}
+
+// readGPUThermalHeadroom returns how far the GPU at devicePath is from its
+// critical temperature relative to it, (crit - current) / crit clamped to
+// [0, 1], from hwmon temp1_input and temp1_crit. ok is false unless both
+// are available.
+func readGPUThermalHeadroom(devicePath string) (ratio float64, ok bool) {
+	dirs, err := filepath.Glob(filepath.Join(devicePath, "hwmon", "hwmon*"))
+	if err != nil {
+		return 0, false
+	}
+	for _, dir := range dirs {
+		current, err := readUintFromFile(filepath.Join(dir, "temp1_input"))
+		if err != nil {
+			continue
+		}
+		crit, err := readUintFromFile(filepath.Join(dir, "temp1_crit"))
+		if err != nil || crit == 0 {
+			continue
+		}
+		ratio = (float64(crit) - float64(current)) / float64(crit)
+		return min(max(ratio, 0), 1), true
+	}
+	return 0, false
+}
+
+// readGPUHwmonValue returns the value of the hwmon attribute name of the GPU
+// at devicePath, from the first hwmon device providing it.
+func readGPUHwmonValue(devicePath, name string) (uint64, bool) {
+	files, err := filepath.Glob(filepath.Join(devicePath, "hwmon", "hwmon*", name))
+	if err != nil {
+		return 0, false
+	}
+	for _, file := range files {
+		if value, err := readUintFromFile(file); err == nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// readGPUBusyPercent returns the utilization of the amdgpu card at
+// devicePath from gpu_busy_percent as a ratio.
+func readGPUBusyPercent(devicePath string) (float64, bool) {
+	percent, err := readUintFromFile(filepath.Join(devicePath, "gpu_busy_percent"))
+	if err != nil {
+		return 0, false
+	}
+	return float64(percent) / 100, true
+}
+
+// sampleGPUUtilization returns the utilization ratio of the GPUs reporting
+// gpu_busy_percent, keyed by gpu_id label. gpu_busy_percent is a point sample, so
+// it's read c.utilSamples times, gpuUtilSampleInterval apart, and averaged.
+func (c *gpuCollector) sampleGPUUtilization(gpus []gpuDevice) map[string]float64 {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for i := range c.utilSamples {
+		if i > 0 {
+			c.sleep(gpuUtilSampleInterval)
+		}
+		for _, gpu := range gpus {
+			if ratio, ok := readGPUBusyPercent(gpu.path); ok {
+				sums[gpu.gpuID()] += ratio
+				counts[gpu.gpuID()]++
+			}
 		}
+		// Don't wait for GPUs without gpu_busy_percent.
+		if len(counts) == 0 {
+			break
+		}
+	}
+	for id, sum := range sums {
+		sums[id] = sum / float64(counts[id])
 	}
-	return false
+	return sums
+}
+
+// readGPUSiblingFunctions returns the PCI class of the other functions of the
+// card at devicePath, such as HDMI audio or USB-C controllers, keyed by their
+// bus ID. Siblings share the domain:bus:device part of busID.
+func readGPUSiblingFunctions(devicePath, busID string) map[string]string {
+	slot, _, ok := strings.Cut(busID, ".")
+	if !ok {
+		return nil
+	}
+	functions, err := filepath.Glob(filepath.Join(filepath.Dir(devicePath), slot+".[0-7]"))
+	if err != nil {
+		return nil
+	}
+	siblings := make(map[string]string)
+	for _, function := range functions {
+		name := filepath.Base(function)
+		if name == busID {
+			continue
+		}
+		class, err := readSysfsFile(filepath.Join(function, "class"))
+		if err != nil {
+			continue
+		}
+		siblings[name] = class
+	}
+	return siblings
+}
+
+// selectTopN returns the c.topN GPUs ranking highest by c.topNBy, in their
+// original order. GPUs without a reading rank last. Temperatures are taken
+// from readings, the sensor readings of the scrape keyed by bus ID.
+func (c *gpuCollector) selectTopN(gpus []gpuDevice, readings map[string]gpuSensorReadings) []gpuDevice {
+	if c.topN <= 0 || len(gpus) <= c.topN {
+		return gpus
+	}
+	scores := make(map[string]float64, len(gpus))
+	for _, gpu := range gpus {
+		var (
+			score float64
+			ok    bool
+		)
+		switch c.topNBy {
+		case gpuTopNByUtilization:
+			score, ok = readGPUBusyPercent(gpu.path)
+		default:
+			score, ok = readings[gpu.busID].hottest()
+		}
+		if !ok {
+			score = math.Inf(-1)
+		}
+		scores[gpu.busID] = score
+	}
+	ranked := slices.Clone(gpus)
+	slices.SortStableFunc(ranked, func(a, b gpuDevice) int {
+		return cmp.Compare(scores[b.busID], scores[a.busID])
+	})
+	top := make(map[string]bool, c.topN)
+	for _, gpu := range ranked[:c.topN] {
+		top[gpu.busID] = true
+	}
+	return slices.DeleteFunc(slices.Clone(gpus), func(gpu gpuDevice) bool {
+		return !top[gpu.busID]
+	})
+}
+
+// gpuAudioFunctionPresent returns 1 if one of the sibling functions of a GPU
+// with the given vendor and class is an audio device (class 0x0403) and 0
+// otherwise. Only NVIDIA and AMD VGA controllers (class 0x0300) are expected
+// to have one, other GPUs get -1: 3D controllers without display outputs and
+// Intel GPUs, whose display audio is served by the chipset's HD audio
+// controller.
+func gpuAudioFunctionPresent(vendorID, class string, siblings map[string]string) float64 {
+	if vendorID != vendorNVIDIA && vendorID != vendorAMD {
+		return -1
+	}
+	if !strings.HasPrefix(class, "0x0300") {
+		return -1
+	}
+	for _, siblingClass := range siblings {
+		if strings.HasPrefix(siblingClass, "0x0403") {
+			return 1
+		}
+	}
+	return 0
+}
+
+// gpuCardFingerprint returns a short hash identifying the physical card,
+// built from its vendor, device and subsystem IDs plus its serial: the NVML
+// UUID or the amdgpu unique_id. Without a serial, cards of the same model
+// share a fingerprint. The bus ID isn't part of it, so a card keeps its
+// fingerprint when moved to another slot.
+func gpuCardFingerprint(gpu gpuDevice) string {
+	subsystemVendor, _ := readSysfsFile(filepath.Join(gpu.path, "subsystem_vendor"))
+	subsystemDevice, _ := readSysfsFile(filepath.Join(gpu.path, "subsystem_device"))
+
+	serial := gpu.uniqueID
+	if gpu.nvml != nil {
+		if uuid, err := gpu.nvml.UUID(); err == nil {
+			serial = uuid
+		}
+	}
+
+	h := fnv.New64a()
+	for _, field := range []string{gpu.vendorID, gpu.deviceID, subsystemVendor, subsystemDevice, serial} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
 }
 
 // getProductName returns human-readable product name
 func getProductName(vendorID, deviceID string) string {
-	if vendorID == vendorNVIDIA {
-		if name, ok := nvidiaProducts[deviceID]; ok {
-			return name
-		}
+	var products map[string]string
+	switch vendorID {
+	case vendorNVIDIA:
+		products = nvidiaProducts
+	case vendorAMD:
+		products = amdProducts
+	case vendorIntel:
+		products = intelProducts
+	}
+	if name, ok := products[deviceID]; ok {
+		return name
 	}
 	// Fallback to device ID
 	return deviceID
 }
 
-func (c *gpuCollector) Update(ch chan<- prometheus.Metric) error {
-	sysfsPath := sysFilePath("bus/pci/devices")
+// dcgmLabelValues returns the values of gpuDCGMLabelNames for the GPU. The
+// UUID falls back to the amdgpu unique_id and device, the nvidia<minor> device
+// node, is empty without NVML. MIG instances aren't exposed, so GPU_I_ID is
+// always empty, as DCGM leaves it for GPUs without MIG.
+func dcgmLabelValues(gpu gpuDevice) []string {
+	uuid, device := gpu.uniqueID, ""
+	if gpu.nvml != nil {
+		uuid, _ = gpu.nvml.UUID()
+		if minor, err := gpu.nvml.MinorNumber(); err == nil {
+			device = fmt.Sprintf("nvidia%d", minor)
+		}
+	}
+	return []string{uuid, device, gpu.model, ""}
+}
+
+// subsystemNames returns the pci.ids names of the GPU's subsystem vendor and
+// device, empty if the subsystem IDs can't be read.
+func (c *gpuCollector) subsystemNames(gpu gpuDevice) []string {
+	if gpu.subsystemVendorID == "" || gpu.subsystemDeviceID == "" {
+		return []string{"", ""}
+	}
+	return []string{
+		c.pciProvider.getVendorName(gpu.subsystemVendorID),
+		c.pciProvider.getSubsystemName(gpu.vendorID, gpu.deviceID, gpu.subsystemVendorID, gpu.subsystemDeviceID),
+	}
+}
+
+// className returns the pci.ids name of the GPU's PCI class, falling back to
+// the class ID when names aren't resolved or the class is unknown.
+func (c *gpuCollector) className(gpu gpuDevice) string {
+	if c.pciProvider == nil || gpu.class == "" {
+		return gpu.class
+	}
+	name := c.pciProvider.getClassName(gpu.class)
+	if strings.HasPrefix(name, "Unknown class") {
+		return gpu.class
+	}
+	return name
+}
+
+// gpuScan is the result of detecting the GPUs.
+type gpuScan struct {
+	gpus []gpuDevice
+	// filtered counts the display controllers skipped by scan per
+	// gpuFilterReasons label.
+	filtered map[string]int
+	// sysfsAccessible is set if the GPU sysfs path could be listed, GPUs
+	// may still come from the DRM fallback without it.
+	sysfsAccessible bool
+}
+
+// gpuFilterReasons maps the skip reasons counted by node_gpu_filtered_total
+// to their reason label.
+var gpuFilterReasons = map[string]string{
+	gpuSkipBMC:      "bmc",
+	gpuSkipVendor:   "unknown_vendor",
+	gpuSkipNoDriver: "no_driver",
+}
+
+// detect returns the GPUs found by scan, falling back to scanDRM if there are
+// none.
+func (c *gpuCollector) detect() (gpuScan, error) {
+	result, err := c.scan()
+	if err != nil || len(result.gpus) == 0 {
+		if drmGPUs, drmErr := c.scanDRM(); drmErr == nil && len(drmGPUs) > 0 {
+			result.gpus, err = drmGPUs, nil
+		}
+	}
+	return result, err
+}
 
-	entries, err := os.ReadDir(sysfsPath)
+// scan walks the GPU sysfs path and returns the GPUs that have a driver bound
+// and the number of display controllers skipped.
+func (c *gpuCollector) scan() (gpuScan, error) {
+	result := gpuScan{filtered: make(map[string]int)}
+	probes, err := c.probeAll()
 	if err != nil {
-		c.logger.Debug("Failed to read PCI devices", "error", err)
-		return ErrNoData
+		return result, err
+	}
+	result.sysfsAccessible = true
+	for _, probe := range probes {
+		if probe.skipReason == "" {
+			result.gpus = append(result.gpus, probe.gpu)
+		} else if reason, ok := gpuFilterReasons[probe.skipReason]; ok {
+			result.filtered[reason]++
+		}
 	}
+	return result, nil
+}
 
-	var gpuMetrics []prometheus.Metric
-	modelCounts := make(map[string]int) // Track count per model
+// probeAll probes every device in the GPU sysfs path.
+func (c *gpuCollector) probeAll() ([]gpuProbe, error) {
+	entries, err := os.ReadDir(c.sysfsPath)
+	if err != nil {
+		return nil, err
+	}
 
+	var probes []gpuProbe
 	for _, entry := range entries {
-		devicePath := filepath.Join(sysfsPath, entry.Name())
+		devicePath := filepath.Join(c.sysfsPath, entry.Name())
+		// bus/pci/devices holds symlinks into the device tree. Devices can
+		// disappear between listing and reading them, e.g. on hot-unplug,
+		// which is not worth logging.
+		if entry.Type()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(devicePath)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					c.logger.Debug("Failed to resolve PCI device", "device", entry.Name(), "error", err)
+				}
+				continue
+			}
+			devicePath = resolved
+		}
+		probes = append(probes, c.probeGPU(entry.Name(), devicePath, true))
+	}
 
-		// Read class
-		classStr, err := readSysfsFile(filepath.Join(devicePath, "class"))
+	return probes, nil
+}
+
+// scanDRM returns the GPUs backing the DRM cards in /sys/class/drm. It's the
+// fallback for restricted environments, e.g. containers, where the PCI class
+// of the devices can't be read but the DRM class is accessible.
+func (c *gpuCollector) scanDRM() ([]gpuDevice, error) {
+	cards, err := filepath.Glob(sysFilePath("class/drm/card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []gpuDevice
+	seen := make(map[string]bool)
+	for _, card := range cards {
+		// Skip connectors such as card0-DP-1.
+		if strings.Contains(filepath.Base(card), "-") {
+			continue
+		}
+		devicePath, err := filepath.EvalSymlinks(filepath.Join(card, "device"))
 		if err != nil {
 			continue
 		}
-		// Class 0x03xxxx = Display controller
-		if !strings.HasPrefix(classStr, "0x03") {
+		busID := filepath.Base(devicePath)
+		if seen[busID] {
 			continue
 		}
+		seen[busID] = true
+		if probe := c.probeGPU(busID, devicePath, false); probe.skipReason == "" {
+			gpus = append(gpus, probe.gpu)
+		}
+	}
 
-		// Read vendor
-		vendorID, err := readSysfsFile(filepath.Join(devicePath, "vendor"))
-		if err != nil {
+	return gpus, nil
+}
+
+// Reasons for probeGPU to skip a device, see --collector.gpu.validate.
+const (
+	gpuSkipNotDisplay = "not a display controller"
+	gpuSkipUnreadable = "PCI IDs can't be read"
+	gpuSkipBMC        = "BMC display controller"
+	gpuSkipVendor     = "vendor not supported"
+	gpuSkipNoDriver   = "no GPU driver bound"
+	// The filters applied to the GPUs found, see logValidation.
+	gpuSkipVRAM         = "VRAM below --collector.gpu.min-vram-bytes"
+	gpuSkipNoRenderNode = "no DRM render node"
+	gpuSkipPerCard      = "not the first display function of the card"
+)
+
+// gpuProbe is the result of probing a PCI device for a GPU.
+type gpuProbe struct {
+	// gpu is the GPU found, for skipped devices only what was read before
+	// skipping it.
+	gpu gpuDevice
+	// skipReason tells why the device isn't exposed, empty for GPUs.
+	skipReason string
+}
+
+// probeGPU probes the device at devicePath. It's a GPU if it's a display
+// controller of a known vendor with a driver bound. With requireClass unset,
+// devices whose PCI class can't be read are accepted.
+func (c *gpuCollector) probeGPU(busID, devicePath string, requireClass bool) gpuProbe {
+	probe := gpuProbe{gpu: gpuDevice{busID: busID, path: devicePath}}
+	skip := func(reason string) gpuProbe {
+		probe.skipReason = reason
+		return probe
+	}
+
+	// Read class
+	classStr, err := readSysfsFile(filepath.Join(devicePath, "class"))
+	if err != nil && requireClass {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger.Debug("Failed to read PCI class", "device", busID, "error", err)
+		}
+		return skip(gpuSkipUnreadable)
+	}
+	// Class 0x03xxxx = Display controller
+	if err == nil && !strings.HasPrefix(classStr, "0x03") {
+		return skip(gpuSkipNotDisplay)
+	}
+	probe.gpu.class = classStr
+
+	// Read vendor
+	vendorID, err := readSysfsFile(filepath.Join(devicePath, "vendor"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger.Debug("Failed to read PCI vendor", "device", busID, "error", err)
+		}
+		return skip(gpuSkipUnreadable)
+	}
+	probe.gpu.vendorID = vendorID
+
+	// Skip BMC vendors
+	if bmcVendors[vendorID] {
+		c.logger.Debug("Skipping BMC device", "vendor", vendorID, "device", busID)
+		return skip(gpuSkipBMC)
+	}
+
+	// Only allow known GPU vendors
+	if !isGPUVendor(vendorID) {
+		c.logger.Debug("Skipping unknown vendor", "vendor", vendorID, "device", busID)
+		return skip(gpuSkipVendor)
+	}
+
+	// Check if GPU driver is loaded
+	driver := readGPUDriver(devicePath)
+	probe.gpu.driver = driver
+	if driver == "" {
+		c.logger.Debug("GPU driver not loaded", "device", busID)
+		if !c.includeUnbound {
+			return skip(gpuSkipNoDriver)
+		}
+	}
+
+	// Read device ID
+	deviceID, err := readSysfsFile(filepath.Join(devicePath, "device"))
+	if err != nil {
+		return skip(gpuSkipUnreadable)
+	}
+
+	var vendorName string
+	switch vendorID {
+	case vendorNVIDIA:
+		vendorName = "NVIDIA Corporation"
+	case vendorAMD:
+		vendorName = "AMD/ATI"
+	case vendorIntel:
+		vendorName = "Intel Corporation"
+	default:
+		vendorName = vendorID
+	}
+
+	gpu := gpuDevice{
+		busID:      busID,
+		path:       devicePath,
+		vendorID:   vendorID,
+		deviceID:   deviceID,
+		vendor:     vendorName,
+		model:      getProductName(vendorID, deviceID),
+		class:      classStr,
+		iommuGroup: readIOMMUGroup(devicePath),
+		minor:      readDRMCardMinor(devicePath),
+		driver:     driver,
+	}
+	gpu.subsystemVendorID, _ = readSysfsFile(filepath.Join(devicePath, "subsystem_vendor"))
+	gpu.subsystemDeviceID, _ = readSysfsFile(filepath.Join(devicePath, "subsystem_device"))
+	if driver != "" {
+		gpu.driverVersion, _ = readSysfsFile(filepath.Join(devicePath, "driver", "module", "version"))
+	}
+	if driver == "amdgpu" {
+		gpu.uniqueID, _ = readSysfsFile(filepath.Join(devicePath, "unique_id"))
+	}
+
+	// Only amdgpu exposes the VRAM size in sysfs.
+	if v, err := readSysfsFile(filepath.Join(devicePath, "mem_info_vram_total")); err == nil {
+		if size, err := strconv.ParseUint(v, 10, 64); err == nil {
+			gpu.memoryTotal = size
+		}
+	}
+
+	c.logger.Debug("Found GPU",
+		"vendor", gpu.vendor,
+		"product", gpu.model,
+		"busID", gpu.busID)
+
+	return gpuProbe{gpu: gpu}
+}
+
+// gpuID returns the gpu_id label value of the GPU.
+func (gpu gpuDevice) gpuID() string {
+	if gpu.id != "" {
+		return gpu.id
+	}
+	return gpu.busID
+}
+
+// assignIDs sets the gpu_id label value of the GPUs to the ID selected by
+// --collector.gpu.id-label. NVIDIA cards report their serial and UUID through
+// NVML, amdgpu cards have their unique_id for both.
+// GPUs without it keep their bus ID and are marked as fallback.
+func (c *gpuCollector) assignIDs(gpus []gpuDevice) {
+	for i := range gpus {
+		gpu := &gpus[i]
+		var id string
+		switch c.idLabel {
+		case gpuIDLabelSerial:
+			if gpu.nvml != nil {
+				id, _ = gpu.nvml.Serial()
+			} else {
+				id = gpu.uniqueID
+			}
+		case gpuIDLabelUUID:
+			if gpu.nvml != nil {
+				id, _ = gpu.nvml.UUID()
+			} else {
+				id = gpu.uniqueID
+			}
+		}
+		if id == "" {
+			c.logger.Debug("GPU ID not available, using the bus ID", "busID", gpu.busID, "idLabel", c.idLabel)
+			gpu.idFallback = true
+			continue
+		}
+		gpu.id = id
+	}
+}
+
+// isGPUVendor reports whether vendorID is one of the supported GPU vendors.
+func isGPUVendor(vendorID string) bool {
+	return vendorID == vendorNVIDIA || vendorID == vendorAMD || vendorID == vendorIntel
+}
+
+// logValidation logs at info level, for each display controller found, its
+// vendor, whether the vendor is supported, the bound driver and why it's
+// skipped, if it is. Besides the reasons of probeGPU, that includes the
+// filters Update applies to the GPUs found.
+func (c *gpuCollector) logValidation() {
+	probes, err := c.probeAll()
+	if err != nil {
+		c.logger.Info("GPU validation failed to read the sysfs path", "path", c.sysfsPath, "error", err)
+		return
+	}
+	var kept []gpuDevice
+	for i, probe := range probes {
+		if probe.skipReason != "" {
+			continue
+		}
+		switch {
+		case c.belowMinVRAM(probe.gpu):
+			probes[i].skipReason = gpuSkipVRAM
+		case c.lacksRenderNode(probe.gpu):
+			probes[i].skipReason = gpuSkipNoRenderNode
+		default:
+			kept = append(kept, probe.gpu)
+		}
+	}
+	if c.perCard {
+		cards := make(map[string]bool)
+		for _, gpu := range groupGPUsByCard(kept) {
+			cards[gpu.busID] = true
+		}
+		for i, probe := range probes {
+			if probe.skipReason == "" && !cards[probe.gpu.busID] {
+				probes[i].skipReason = gpuSkipPerCard
+			}
+		}
+	}
+
+	found := 0
+	for _, probe := range probes {
+		if probe.skipReason == gpuSkipNotDisplay {
+			continue
+		}
+		found++
+		skipReason := probe.skipReason
+		if skipReason == "" {
+			skipReason = "none"
+		}
+		c.logger.Info("GPU validation",
+			"device", probe.gpu.busID,
+			"vendor", probe.gpu.vendorID,
+			"supported_vendor", isGPUVendor(probe.gpu.vendorID),
+			"driver", probe.gpu.driver,
+			"skip_reason", skipReason)
+	}
+	if found == 0 {
+		c.logger.Info("GPU validation found no display controllers", "path", c.sysfsPath)
+	}
+}
+
+// filterByVRAM drops the GPUs with less VRAM than --collector.gpu.min-vram-bytes.
+// GPUs with unknown VRAM are kept so that compute cards aren't lost when the
+// size can't be read.
+func (c *gpuCollector) filterByVRAM(gpus []gpuDevice) []gpuDevice {
+	if c.minVRAM == 0 {
+		return gpus
+	}
+	return slices.DeleteFunc(gpus, func(gpu gpuDevice) bool {
+		if c.belowMinVRAM(gpu) {
+			c.logger.Debug("Skipping GPU below the VRAM threshold", "busID", gpu.busID, "memoryTotal", gpu.memoryTotal)
+			return true
+		}
+		return false
+	})
+}
+
+// belowMinVRAM reports whether the GPU is dropped by filterByVRAM.
+func (c *gpuCollector) belowMinVRAM(gpu gpuDevice) bool {
+	return gpu.memoryTotal != 0 && gpu.memoryTotal < c.minVRAM
+}
+
+// filterByRenderNode drops the GPUs without a DRM render node if
+// --collector.gpu.require-render-node is set.
+func (c *gpuCollector) filterByRenderNode(gpus []gpuDevice) []gpuDevice {
+	if !c.requireRenderNode {
+		return gpus
+	}
+	return slices.DeleteFunc(gpus, func(gpu gpuDevice) bool {
+		if c.lacksRenderNode(gpu) {
+			c.logger.Debug("Skipping GPU without a render node", "busID", gpu.busID)
+			return true
+		}
+		return false
+	})
+}
+
+// lacksRenderNode reports whether the GPU is dropped by filterByRenderNode.
+func (c *gpuCollector) lacksRenderNode(gpu gpuDevice) bool {
+	return c.requireRenderNode && !hasDRMRenderNode(gpu.path)
+}
+
+// gpuDriverVersionMismatch returns 1 if GPUs of the same vendor report
+// different driver versions, 0 otherwise. ok is false unless at least two
+// GPUs of a vendor report their driver version.
+func gpuDriverVersionMismatch(gpus []gpuDevice) (mismatch float64, ok bool) {
+	versions := make(map[string]map[string]bool)
+	counts := make(map[string]int)
+	for _, gpu := range gpus {
+		if gpu.driverVersion == "" {
+			continue
+		}
+		if versions[gpu.vendorID] == nil {
+			versions[gpu.vendorID] = make(map[string]bool)
+		}
+		versions[gpu.vendorID][gpu.driverVersion] = true
+		counts[gpu.vendorID]++
+	}
+	for vendor, count := range counts {
+		if count < 2 {
 			continue
 		}
+		ok = true
+		if len(versions[vendor]) > 1 {
+			mismatch = 1
+		}
+	}
+	return mismatch, ok
+}
+
+// gpuOtherModel is the model the GPUs beyond --collector.gpu.max-models are
+// counted as.
+const gpuOtherModel = "other"
 
-		// Skip BMC vendors
-		if bmcVendors[vendorID] {
-			c.logger.Debug("Skipping BMC device", "vendor", vendorID, "device", entry.Name())
+// capGPUModels limits counts to limit models: the limit-1 most common models are
+// kept and the others merged into gpuOtherModel. Ties are broken by model
+// name to keep the series stable across scrapes.
+func capGPUModels(counts map[string]int, limit int) map[string]int {
+	if limit <= 0 || len(counts) <= limit {
+		return counts
+	}
+	models := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(models, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	capped := make(map[string]int, limit)
+	for i, model := range models {
+		if i < limit-1 {
+			capped[model] = counts[model]
+		} else {
+			capped[gpuOtherModel] += counts[model]
+		}
+	}
+	return capped
+}
+
+// groupGPUsByCard keeps a single GPU per physical card, the display function
+// with the lowest function number. Functions of a card share the
+// domain:bus:device part of their bus ID, e.g. 0000:17:00.
+func groupGPUsByCard(gpus []gpuDevice) []gpuDevice {
+	cards := make(map[string]int)
+	var grouped []gpuDevice
+	for _, gpu := range gpus {
+		card, function, _ := strings.Cut(gpu.busID, ".")
+		i, ok := cards[card]
+		if !ok {
+			cards[card] = len(grouped)
+			grouped = append(grouped, gpu)
 			continue
 		}
+		if _, kept, _ := strings.Cut(grouped[i].busID, "."); function < kept {
+			grouped[i] = gpu
+		}
+	}
+	return grouped
+}
+
+func (c *gpuCollector) Update(ch chan<- prometheus.Metric) error {
+	var (
+		result gpuScan
+		err    error
+	)
+	if generation := scrapeGeneration.Load(); generation != 0 {
+		// Detected once per scrape and shared with the other collectors.
+		result, err = gpuInventory.get(generation)
+	} else {
+		result, err = c.detect()
+	}
+
+	// Tells a masked or unmounted /sys apart from a node without GPUs.
+	if !c.infoOnly {
+		sysfsAccessible := 0.0
+		if result.sysfsAccessible {
+			sysfsAccessible = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "sysfs_accessible"),
+				"Whether the PCI devices directory scanned for GPUs, --collector.gpu.sysfs-path, could be read (0/1).",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			sysfsAccessible,
+		)
+	}
+
+	if err != nil {
+		c.logger.Debug("Failed to read PCI devices", "error", err)
+		return ErrNoData
+	}
+	gpus := result.gpus
+
+	// Also exposed without GPUs, to tell why none were found.
+	if !c.infoOnly {
+		for _, reason := range gpuFilterReasons {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, c.subsystem, "filtered_total"),
+					"Number of display controllers skipped by the GPU scan: bmc for BMC graphics, unknown_vendor for unsupported vendors and no_driver for GPUs without a driver bound.",
+					[]string{"reason"}, nil,
+				),
+				prometheus.GaugeValue,
+				float64(result.filtered[reason]),
+				reason,
+			)
+		}
+	}
+
+	// Only expose metrics if GPUs with drivers are detected
+	if len(gpus) == 0 {
+		return nil
+	}
+
+	if c.nvml != nil {
+		c.attachNVML(gpus)
+	}
+	if c.idLabel != "" {
+		c.assignIDs(gpus)
+	}
+
+	gpus = c.filterByVRAM(gpus)
+	gpus = c.filterByRenderNode(gpus)
+	if c.perCard {
+		gpus = groupGPUsByCard(gpus)
+	}
+	if len(gpus) == 0 {
+		return nil
+	}
+
+	infoLabelNames := slices.Clone(gpuInfoLabelNames)
+	if c.pciProvider != nil {
+		infoLabelNames = append(infoLabelNames, gpuNameLabelNames...)
+	}
+	if c.fingerprint {
+		infoLabelNames = append(infoLabelNames, gpuFingerprintLabel)
+	}
+	if c.dcgmLabels {
+		infoLabelNames = append(infoLabelNames, gpuDCGMLabelNames...)
+	}
+	if c.includeUnbound {
+		infoLabelNames = append(infoLabelNames, gpuDriverLabel)
+	}
+	if c.idLabel != "" {
+		infoLabelNames = append(infoLabelNames, gpuIDFallbackLabel)
+	}
+	if c.labels != nil {
+		infoLabelNames = append(infoLabelNames, c.labels.names...)
+	}
+	infoDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "info"),
+		"Information about the GPU.",
+		infoLabelNames, nil,
+	)
+
+	modelCounts := make(map[string]int) // Track count per model
+	for _, gpu := range gpus {
+		modelCounts[gpu.model]++
+
+		values := []string{gpu.gpuID(), gpu.vendor, gpu.model, gpu.vendorID, gpu.deviceID, gpu.iommuGroup, gpu.minor, c.className(gpu),
+			gpu.subsystemVendorID, gpu.subsystemDeviceID, gpu.uniqueID}
+		if c.pciProvider != nil {
+			values = append(values, c.subsystemNames(gpu)...)
+		}
+		if c.fingerprint {
+			values = append(values, gpuCardFingerprint(gpu))
+		}
+		if c.dcgmLabels {
+			values = append(values, dcgmLabelValues(gpu)...)
+		}
+		if c.includeUnbound {
+			values = append(values, gpu.driver)
+		}
+		if c.idLabel != "" {
+			fallback := ""
+			if gpu.idFallback {
+				fallback = gpuIDLabelBus
+			}
+			values = append(values, fallback)
+		}
+		if c.labels != nil {
+			values = append(values, c.labels.valuesFor(gpu.busID)...)
+		}
+		ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1, values...)
+	}
 
-		// Only allow known GPU vendors
-		if vendorID != vendorNVIDIA && vendorID != vendorAMD && vendorID != vendorIntel {
-			c.logger.Debug("Skipping unknown vendor", "vendor", vendorID, "device", entry.Name())
+	// Emit cards_total per model
+	for model, count := range capGPUModels(modelCounts, c.maxModels) {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "cards_total"),
+				"Total number of GPU cards detected.",
+				[]string{"model"}, nil,
+			),
+			prometheus.GaugeValue,
+			float64(count),
+			model,
+		)
+	}
+
+	// Inventory only, skip the health metrics and their sysfs reads.
+	if c.infoOnly {
+		return nil
+	}
+
+	if c.includeUnbound {
+		for _, gpu := range gpus {
+			bound := 0.0
+			if gpu.driver != "" {
+				bound = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, c.subsystem, "driver_bound"),
+					"Whether a GPU driver is bound to the GPU (0/1).",
+					[]string{"gpu_id"}, nil,
+				),
+				prometheus.GaugeValue,
+				bound,
+				gpu.gpuID(),
+			)
+		}
+	}
+
+	vendors := make(map[string]bool)
+	for _, gpu := range gpus {
+		vendors[gpu.vendorID] = true
+	}
+	mixedVendors := 0.0
+	if len(vendors) > 1 {
+		mixedVendors = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, c.subsystem, "mixed_vendors"),
+			"Whether the node has GPUs from more than one vendor (0/1).",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		mixedVendors,
+	)
+
+	if mismatch, ok := gpuDriverVersionMismatch(gpus); ok {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "driver_version_mismatch"),
+				"Whether GPUs of the same vendor run different driver versions (0/1).",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			mismatch,
+		)
+	}
+
+	// VRAM is only known for amdgpu cards and, with NVML enabled, NVIDIA
+	// cards. Other GPUs don't contribute to the node total.
+	var memoryTotal uint64
+	var memoryKnown bool
+	for _, gpu := range gpus {
+		if gpu.memoryTotal == 0 {
 			continue
 		}
+		memoryTotal += gpu.memoryTotal
+		memoryKnown = true
 
-		// Check if GPU driver is loaded
-		if !isGPUDriverLoaded(devicePath) {
-			c.logger.Debug("GPU driver not loaded", "device", entry.Name())
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "memory_total_bytes"),
+				"Total VRAM of the GPU in bytes.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			float64(gpu.memoryTotal),
+			gpu.gpuID(),
+		)
+	}
+	if memoryKnown {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "memory_total_bytes_node"),
+				"Total VRAM in bytes of all GPUs reporting their memory size.",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(memoryTotal),
+		)
+	}
+
+	readings := c.readAllSensors(gpus)
+	gpus = c.selectTopN(gpus, readings)
+
+	for _, gpu := range gpus {
+		// Skipped when unknown, like node_pcidevice_numa_node.
+		numaNode := readNumaNode(gpu.path)
+		if numaNode == -1 {
 			continue
 		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "numa_node"),
+				"NUMA node number the GPU is attached to.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			numaNode,
+			gpu.gpuID(),
+		)
+	}
 
-		// Read device ID
-		deviceID, err := readSysfsFile(filepath.Join(devicePath, "device"))
+	for _, gpu := range gpus {
+		// Only some drivers expose a reset counter, skip silently otherwise.
+		resets, err := readUintFromFile(filepath.Join(gpu.path, "reset_count"))
 		if err != nil {
 			continue
 		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "reset_total"),
+				"Number of times the GPU has been reset by the driver.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.CounterValue,
+			float64(resets),
+			gpu.gpuID(),
+		)
+	}
 
-		busID := entry.Name()
-		productName := getProductName(vendorID, deviceID)
+	for _, gpu := range gpus {
+		// amdgpu counts the PCIe replays of the link, skip cards without.
+		replays, err := readUintFromFile(filepath.Join(gpu.path, "pcie_replay_count"))
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "pcie_replay_total"),
+				"Number of PCIe packet replays on the link of the GPU from amdgpu pcie_replay_count. A rising count is an early sign of a degrading link.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.CounterValue,
+			float64(replays),
+			gpu.gpuID(),
+		)
+	}
 
-		// Track model count
-		modelCounts[productName]++
+	c.updateSensors(ch, gpus, readings)
 
-		var vendorName string
-		switch vendorID {
-		case vendorNVIDIA:
-			vendorName = "NVIDIA Corporation"
-		case vendorAMD:
-			vendorName = "AMD/ATI"
-		case vendorIntel:
-			vendorName = "Intel Corporation"
-		default:
-			vendorName = vendorID
+	for _, gpu := range gpus {
+		headroom, ok := readGPUThermalHeadroom(gpu.path)
+		if !ok {
+			continue
 		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "thermal_headroom_ratio"),
+				"Distance of the GPU temperature to its critical threshold relative to the threshold, 0 means the GPU is at or above it.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			headroom,
+			gpu.gpuID(),
+		)
+	}
+
+	utilization := c.sampleGPUUtilization(gpus)
+	for id, ratio := range utilization {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "utilization_ratio"),
+				"Utilization of the GPU from amdgpu gpu_busy_percent (0-1), averaged over --collector.gpu.util-samples reads.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			ratio,
+			id,
+		)
+	}
 
-		c.logger.Debug("Found GPU",
-			"vendor", vendorName,
-			"product", productName,
-			"busID", busID)
+	c.updateMemoryClockThrottle(ch, gpus, readings, utilization)
 
-		gpuMetrics = append(gpuMetrics, prometheus.MustNewConstMetric(
+	// amdgpu exposes the fan control mode, 0 for no control, 1 for manual and
+	// 2 for automatic, and the target speed on cards with a fan.
+	for _, gpu := range gpus {
+		if mode, ok := readGPUHwmonValue(gpu.path, "pwm1_enable"); ok {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, c.subsystem, "fan_control_mode"),
+					"Fan control mode of the GPU from hwmon pwm1_enable: 0 none, 1 manual, 2 automatic.",
+					[]string{"gpu_id"}, nil,
+				),
+				prometheus.GaugeValue,
+				float64(mode),
+				gpu.gpuID(),
+			)
+		}
+		if target, ok := readGPUHwmonValue(gpu.path, "fan1_target"); ok {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, c.subsystem, "fan_target_rpm"),
+					"Target fan speed of the GPU in RPM from hwmon fan1_target.",
+					[]string{"gpu_id"}, nil,
+				),
+				prometheus.GaugeValue,
+				float64(target),
+				gpu.gpuID(),
+			)
+		}
+	}
+
+	for _, gpu := range gpus {
+		siblings := readGPUSiblingFunctions(gpu.path, gpu.busID)
+		for function, class := range siblings {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(
+					prometheus.BuildFQName(namespace, c.subsystem, "function_info"),
+					"Other PCI functions of the GPU's card, such as HDMI audio, value is always 1.",
+					[]string{"gpu_id", "function_id", "function_class"}, nil,
+				),
+				prometheus.GaugeValue,
+				1,
+				gpu.gpuID(), function, class,
+			)
+		}
+		ch <- prometheus.MustNewConstMetric(
 			prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, "gpu", "info"),
-				"Information about the GPU.",
-				[]string{"gpu_id", "vendor", "model", "vendor_id", "device_id"}, nil,
+				prometheus.BuildFQName(namespace, c.subsystem, "audio_function_present"),
+				"Whether the GPU's card exposes its HDMI/DisplayPort audio function (0/1), -1 for GPUs not expected to have one: those that aren't VGA controllers, e.g. data center cards without display outputs, and Intel GPUs.",
+				[]string{"gpu_id"}, nil,
 			),
 			prometheus.GaugeValue,
-			1,
-			busID, vendorName, productName, vendorID, deviceID,
-		))
+			gpuAudioFunctionPresent(gpu.vendorID, gpu.class, siblings),
+			gpu.gpuID(),
+		)
 	}
 
-	// Only expose metrics if GPUs with drivers are detected
-	if len(modelCounts) > 0 {
-		for _, m := range gpuMetrics {
-			ch <- m
+	for _, gpu := range gpus {
+		connected, total, ok := readDRMConnectors(gpu.path)
+		if !ok {
+			continue
 		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "connected_outputs"),
+				"Number of display connectors of the GPU with a display connected.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			float64(connected),
+			gpu.gpuID(),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, c.subsystem, "outputs"),
+				"Number of display connectors of the GPU.",
+				[]string{"gpu_id"}, nil,
+			),
+			prometheus.GaugeValue,
+			float64(total),
+			gpu.gpuID(),
+		)
+	}
 
-		// Emit cards_total per model
-		for model, count := range modelCounts {
+	for _, gpu := range gpus {
+		for component, version := range readAMDGPUFirmwareVersions(gpu.path) {
 			ch <- prometheus.MustNewConstMetric(
 				prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, "gpu", "cards_total"),
-					"Total number of GPU cards detected.",
-					[]string{"model"}, nil,
+					prometheus.BuildFQName(namespace, c.subsystem, "firmware_version_info"),
+					"Version of the GPU firmware per component from amdgpu fw_version, value is always 1.",
+					[]string{"gpu_id", "component", "version"}, nil,
 				),
 				prometheus.GaugeValue,
-				float64(count),
-				model,
+				1,
+				gpu.gpuID(), component, version,
 			)
 		}
 	}
 
+	c.updateXGMI(ch, gpus)
+	c.updateECC(ch, gpus)
+	c.updatePowerFeatures(ch, gpus)
+
+	if c.nvml != nil {
+		c.updateNVML(ch, gpus)
+	}
+
 	return nil
 }
@@ -16,9 +16,22 @@
 package collector
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestGPUCollector(t *testing.T) {
@@ -45,3 +58,1043 @@ func TestGPUCollector(t *testing.T) {
 
 	_ = c
 }
+
+type testGPUCollector struct {
+	c Collector
+}
+
+func (tc testGPUCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := tc.c.Update(ch); err != nil {
+		panic(fmt.Errorf("failed to update collector: %s", err))
+	}
+}
+
+func (tc testGPUCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(tc, ch)
+}
+
+func newTestGPURegistry(t *testing.T) *prometheus.Registry {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: newTestGPUCollector(t)})
+	return reg
+}
+
+// newTestGPUCollector returns a GPU collector reading fixtures/sys, with the
+// flag defaults applied.
+func newTestGPUCollector(t *testing.T) *gpuCollector {
+	t.Helper()
+	*sysPath = "fixtures/sys"
+	// Flag defaults are only applied by kingpin.Parse.
+	if *gpuSysfsPath == "" {
+		*gpuSysfsPath = "bus/pci/devices"
+	}
+	if *gpuSource == "" {
+		*gpuSource = gpuSourceAuto
+	}
+	if *gpuMetricPrefix == "" {
+		*gpuMetricPrefix = "gpu"
+	}
+	if *gpuMemClockThrottleRatio == 0 {
+		*gpuMemClockThrottleRatio = 0.5
+	}
+	if *gpuMemClockThrottleMinUtil == 0 {
+		*gpuMemClockThrottleMinUtil = 0.8
+	}
+
+	c, err := NewGPUCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*gpuCollector)
+}
+
+// writeTestGPUDevice creates the PCI device busID in dir, a GPU sysfs path,
+// with the given attribute files and binds driver to it unless it's empty.
+// File names may contain directories, e.g. hwmon/hwmon0/temp1_input. It
+// returns the path of the device.
+func writeTestGPUDevice(t *testing.T, dir, busID, driver string, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(dir, busID)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, value := range files {
+		file = filepath.Join(path, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if driver != "" {
+		if err := os.Symlink("../../../bus/pci/drivers/"+driver, filepath.Join(path, "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestGPUCollectorMetricPrefix(t *testing.T) {
+	*gpuMetricPrefix = "mygpu"
+	t.Cleanup(func() { *gpuMetricPrefix = "gpu" })
+	reg := newTestGPURegistry(t)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) == 0 {
+		t.Fatal("no metrics gathered")
+	}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "node_mygpu_") {
+			t.Errorf("metric %q doesn't use the prefix", family.GetName())
+		}
+	}
+
+	*gpuMetricPrefix = "my-gpu"
+	if _, err := NewGPUCollector(slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("expected error for invalid metric prefix")
+	}
+}
+
+func TestGPUCollectorInfoOnly(t *testing.T) {
+	*gpuInfoOnly = true
+	t.Cleanup(func() { *gpuInfoOnly = false })
+	reg := newTestGPURegistry(t)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	if want := []string{"node_gpu_cards_total", "node_gpu_info"}; !slices.Equal(names, want) {
+		t.Errorf("got metrics %v, want %v", names, want)
+	}
+}
+
+func TestGPUCollectorIDLabelFallback(t *testing.T) {
+	*gpuIDLabel = gpuIDLabelSerial
+	t.Cleanup(func() { *gpuIDLabel = gpuIDLabelBus })
+	reg := newTestGPURegistry(t)
+
+	// The vfio-bound 0000:c1:00.0 has no unique_id and keeps its bus ID.
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="8f2c3a1d5e7b9046",id_fallback="",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="8f2c3a1d5e7b9147",id_fallback="",iommu_group="40",minor="1",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9147",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740f",gpu_id="0000:c1:00.0",id_fallback="bus",iommu_group="62",minor="",model="AMD Instinct MI210",subsystem_device_id="0x0c34",subsystem_vendor_id="0x1002",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+# HELP node_gpu_memory_total_bytes Total VRAM of the GPU in bytes.
+# TYPE node_gpu_memory_total_bytes gauge
+node_gpu_memory_total_bytes{gpu_id="8f2c3a1d5e7b9046"} 6.8719476736e+10
+node_gpu_memory_total_bytes{gpu_id="8f2c3a1d5e7b9147"} 6.8719476736e+10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info", "node_gpu_memory_total_bytes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorUniqueID(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, family := range families {
+		if family.GetName() != "node_gpu_info" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			got[labels["gpu_id"]] = labels["unique_id"]
+		}
+	}
+	// The vfio-bound 0000:c1:00.0 isn't driven by amdgpu.
+	want := map[string]string{
+		"0000:83:00.0": "8f2c3a1d5e7b9046",
+		"0000:84:00.0": "8f2c3a1d5e7b9147",
+		"0000:c1:00.0": "",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got unique IDs %v, want %v", got, want)
+	}
+}
+
+func TestGPUCollectorVFIO(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// 0000:c1:00.0 is an AMD Instinct MI210 bound to vfio-pci.
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:84:00.0",iommu_group="40",minor="1",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9147",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740f",gpu_id="0000:c1:00.0",iommu_group="62",minor="",model="AMD Instinct MI210",subsystem_device_id="0x0c34",subsystem_vendor_id="0x1002",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorMemoryTotal(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// Both amdgpu cards report 64GiB of VRAM, the vfio-bound card reports
+	// nothing and is left out of the node total.
+	expected := `# HELP node_gpu_memory_total_bytes Total VRAM of the GPU in bytes.
+# TYPE node_gpu_memory_total_bytes gauge
+node_gpu_memory_total_bytes{gpu_id="0000:83:00.0"} 6.8719476736e+10
+node_gpu_memory_total_bytes{gpu_id="0000:84:00.0"} 6.8719476736e+10
+# HELP node_gpu_memory_total_bytes_node Total VRAM in bytes of all GPUs reporting their memory size.
+# TYPE node_gpu_memory_total_bytes_node gauge
+node_gpu_memory_total_bytes_node 1.37438953472e+11
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_memory_total_bytes", "node_gpu_memory_total_bytes_node"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadIOMMUGroup(t *testing.T) {
+	for path, want := range map[string]string{
+		"fixtures/sys/bus/pci/devices/0000:83:00.0":    "40",
+		"fixtures/sys/bus/pci/devices/0000:84:00.0":    "40",
+		"fixtures/sys/bus/pci/devices/0000:c1:00.0":    "62",
+		"fixtures/sys/devices/pci0000:00/0000:00:00.0": "-1",
+	} {
+		if got := readIOMMUGroup(path); got != want {
+			t.Errorf("%s: got IOMMU group %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestGPUCollectorSysfsPath(t *testing.T) {
+	dir := t.TempDir()
+	device, err := filepath.Abs("fixtures/sys/devices/pci0000:c0/0000:c1:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(device, filepath.Join(dir, "0000:c1:00.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740f",gpu_id="0000:c1:00.0",iommu_group="62",minor="",model="AMD Instinct MI210",subsystem_device_id="0x0c34",subsystem_vendor_id="0x1002",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorSysfsAccessible(t *testing.T) {
+	expected := `# HELP node_gpu_sysfs_accessible Whether the PCI devices directory scanned for GPUs, --collector.gpu.sysfs-path, could be read (0/1).
+# TYPE node_gpu_sysfs_accessible gauge
+node_gpu_sysfs_accessible 1
+`
+	if err := testutil.GatherAndCompare(newTestGPURegistry(t), strings.NewReader(expected), "node_gpu_sysfs_accessible"); err != nil {
+		t.Fatal(err)
+	}
+
+	*gpuSysfsPath = filepath.Join(t.TempDir(), "nonexistent")
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	c := newTestGPUCollector(t)
+
+	// GPUs may still be found through the DRM fallback.
+	ch := make(chan prometheus.Metric, 1000)
+	if err := c.Update(ch); err != nil && !errors.Is(err, ErrNoData) {
+		t.Fatal(err)
+	}
+	close(ch)
+	var found bool
+	for m := range ch {
+		if !strings.Contains(m.Desc().String(), `"node_gpu_sysfs_accessible"`) {
+			continue
+		}
+		found = true
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if got := metric.GetGauge().GetValue(); got != 0 {
+			t.Errorf("got node_gpu_sysfs_accessible %v, want 0", got)
+		}
+	}
+	if !found {
+		t.Error("node_gpu_sysfs_accessible not exposed")
+	}
+}
+
+func TestGPUCollectorFirmwareVersion(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// Only 0000:83:00.0 has a fw_version directory.
+	expected := `# HELP node_gpu_firmware_version_info Version of the GPU firmware per component from amdgpu fw_version, value is always 1.
+# TYPE node_gpu_firmware_version_info gauge
+node_gpu_firmware_version_info{component="sdma",gpu_id="0000:83:00.0",version="0x00000009"} 1
+node_gpu_firmware_version_info{component="smc",gpu_id="0000:83:00.0",version="0x00453200"} 1
+node_gpu_firmware_version_info{component="vcn",gpu_id="0000:83:00.0",version="0x0110101b"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_firmware_version_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorResetCount(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_reset_total Number of times the GPU has been reset by the driver.
+# TYPE node_gpu_reset_total counter
+node_gpu_reset_total{gpu_id="0000:83:00.0"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_reset_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorPCIeReplays(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// Only 0000:83:00.0 has a pcie_replay_count file.
+	expected := `# HELP node_gpu_pcie_replay_total Number of PCIe packet replays on the link of the GPU from amdgpu pcie_replay_count. A rising count is an early sign of a degrading link.
+# TYPE node_gpu_pcie_replay_total counter
+node_gpu_pcie_replay_total{gpu_id="0000:83:00.0"} 12
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_pcie_replay_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadDRMCardMinor(t *testing.T) {
+	for path, want := range map[string]string{
+		// Minor read from drm/card0/dev.
+		"fixtures/sys/bus/pci/devices/0000:83:00.0": "0",
+		// No dev attribute, minor taken from the card1 directory name.
+		"fixtures/sys/bus/pci/devices/0000:84:00.0": "1",
+		// Bound to vfio-pci, no DRM node.
+		"fixtures/sys/bus/pci/devices/0000:c1:00.0": "",
+	} {
+		if got := readDRMCardMinor(path); got != want {
+			t.Errorf("%s: want minor %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestGPUCollectorConnectedOutputs(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// card0 of 0000:83:00.0 has a connected DP and a disconnected HDMI
+	// connector, card1 of 0000:84:00.0 none. 0000:c1:00.0 has no DRM node.
+	expected := `# HELP node_gpu_connected_outputs Number of display connectors of the GPU with a display connected.
+# TYPE node_gpu_connected_outputs gauge
+node_gpu_connected_outputs{gpu_id="0000:83:00.0"} 1
+node_gpu_connected_outputs{gpu_id="0000:84:00.0"} 0
+# HELP node_gpu_outputs Number of display connectors of the GPU.
+# TYPE node_gpu_outputs gauge
+node_gpu_outputs{gpu_id="0000:83:00.0"} 2
+node_gpu_outputs{gpu_id="0000:84:00.0"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_connected_outputs", "node_gpu_outputs"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorPower(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// 0000:83:00.0 has two rails and both average and input for rail 1,
+	// 0000:84:00.0 only reports power1_input.
+	expected := `# HELP node_gpu_power_watts Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.
+# TYPE node_gpu_power_watts gauge
+node_gpu_power_watts{gpu_id="0000:83:00.0",rail="1"} 285
+node_gpu_power_watts{gpu_id="0000:83:00.0",rail="2"} 42
+node_gpu_power_watts{gpu_id="0000:84:00.0",rail="1"} 130
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_power_watts"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorTemperature(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// hwmon of 0000:83:00.0 labels its sensors, including the HBM one,
+	// 0000:84:00.0 has an unlabeled temp1.
+	expected := `# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:83:00.0",sensor="edge"} 80
+node_gpu_temperature_celsius{gpu_id="0000:83:00.0",sensor="junction"} 92
+node_gpu_temperature_celsius{gpu_id="0000:83:00.0",sensor="mem"} 86
+node_gpu_temperature_celsius{gpu_id="0000:84:00.0",sensor="temp1"} 45
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_temperature_celsius"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorThermalHeadroom(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// 0000:83:00.0 is at 80C with a critical threshold of 100C, 0000:84:00.0
+	// has no threshold.
+	expected := `# HELP node_gpu_thermal_headroom_ratio Distance of the GPU temperature to its critical threshold relative to the threshold, 0 means the GPU is at or above it.
+# TYPE node_gpu_thermal_headroom_ratio gauge
+node_gpu_thermal_headroom_ratio{gpu_id="0000:83:00.0"} 0.2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_thermal_headroom_ratio"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorUtilization(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// Only 0000:83:00.0 reports gpu_busy_percent.
+	expected := `# HELP node_gpu_utilization_ratio Utilization of the GPU from amdgpu gpu_busy_percent (0-1), averaged over --collector.gpu.util-samples reads.
+# TYPE node_gpu_utilization_ratio gauge
+node_gpu_utilization_ratio{gpu_id="0000:83:00.0"} 0.42
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_utilization_ratio"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUSampleUtilization(t *testing.T) {
+	dir := t.TempDir()
+	busy := filepath.Join(dir, "gpu_busy_percent")
+	if err := os.WriteFile(busy, []byte("10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Each wait between two reads moves the GPU to the next sample.
+	samples := []string{"30", "80", "40"}
+	var slept time.Duration
+	c := &gpuCollector{
+		utilSamples: 4,
+		sleep: func(d time.Duration) {
+			slept += d
+			if err := os.WriteFile(busy, []byte(samples[0]+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			samples = samples[1:]
+		},
+	}
+
+	got := c.sampleGPUUtilization([]gpuDevice{
+		{busID: "0000:03:00.0", path: dir},
+		// No gpu_busy_percent.
+		{busID: "0000:04:00.0", path: t.TempDir()},
+	})
+	if want := map[string]float64{"0000:03:00.0": 0.4}; !maps.Equal(got, want) {
+		t.Errorf("want utilization %v, got %v", want, got)
+	}
+	if want := 3 * gpuUtilSampleInterval; slept != want {
+		t.Errorf("want %v spent sampling, got %v", want, slept)
+	}
+}
+
+func TestGPUCollectorFanControl(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// 0000:84:00.0 runs a manual fan curve targeting 2500 RPM, 0000:83:00.0
+	// has no fan control.
+	expected := `# HELP node_gpu_fan_control_mode Fan control mode of the GPU from hwmon pwm1_enable: 0 none, 1 manual, 2 automatic.
+# TYPE node_gpu_fan_control_mode gauge
+node_gpu_fan_control_mode{gpu_id="0000:84:00.0"} 1
+# HELP node_gpu_fan_target_rpm Target fan speed of the GPU in RPM from hwmon fan1_target.
+# TYPE node_gpu_fan_target_rpm gauge
+node_gpu_fan_target_rpm{gpu_id="0000:84:00.0"} 2500
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_fan_control_mode", "node_gpu_fan_target_rpm"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorMinVRAM(t *testing.T) {
+	c := &gpuCollector{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		minVRAM: 16 << 30,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:01:00.0", memoryTotal: 2 << 30},
+		{busID: "0000:17:00.0", memoryTotal: 40 << 30},
+		// VRAM unknown, kept.
+		{busID: "0000:65:00.0"},
+	}
+
+	var got []string
+	for _, gpu := range c.filterByVRAM(gpus) {
+		got = append(got, gpu.busID)
+	}
+	if want := []string{"0000:17:00.0", "0000:65:00.0"}; !slices.Equal(got, want) {
+		t.Errorf("got GPUs %q, want %q", got, want)
+	}
+}
+
+func TestGPUCollectorRequireRenderNode(t *testing.T) {
+	*gpuRequireRender = true
+	t.Cleanup(func() { *gpuRequireRender = false })
+	reg := newTestGPURegistry(t)
+
+	// Only 0000:83:00.0 has a renderD128 node, 0000:84:00.0 is display-only
+	// and 0000:c1:00.0 has no DRM node at all.
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorFingerprint(t *testing.T) {
+	*gpuFingerprint = true
+	t.Cleanup(func() { *gpuFingerprint = false })
+	reg := newTestGPURegistry(t)
+
+	scrape := func() map[string]string {
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprints := make(map[string]string)
+		for _, family := range families {
+			if family.GetName() != "node_gpu_info" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				var gpuID, fingerprint string
+				for _, label := range metric.GetLabel() {
+					switch label.GetName() {
+					case "gpu_id":
+						gpuID = label.GetValue()
+					case "fingerprint":
+						fingerprint = label.GetValue()
+					}
+				}
+				fingerprints[gpuID] = fingerprint
+			}
+		}
+		return fingerprints
+	}
+
+	first := scrape()
+	if len(first) != 3 {
+		t.Fatalf("got fingerprints for %d GPUs, want 3", len(first))
+	}
+	if second := scrape(); !maps.Equal(first, second) {
+		t.Errorf("fingerprints changed between scrapes: %v, then %v", first, second)
+	}
+	// 0000:83:00.0 and 0000:84:00.0 are the same model and only differ by
+	// their unique_id.
+	seen := make(map[string]string)
+	for gpuID, fingerprint := range first {
+		if other, ok := seen[fingerprint]; ok {
+			t.Errorf("%s and %s share fingerprint %s", gpuID, other, fingerprint)
+		}
+		seen[fingerprint] = gpuID
+	}
+	// The hash is fixed, a card must keep its fingerprint across versions.
+	if got, want := first["0000:83:00.0"], "ee2c854d2506302a"; got != want {
+		t.Errorf("got fingerprint %s, want %s", got, want)
+	}
+}
+
+func TestGPUCollectorDRMFallback(t *testing.T) {
+	// The PCI bus walk finds nothing, as when the class files can't be read.
+	*gpuSysfsPath = t.TempDir()
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	reg := newTestGPURegistry(t)
+
+	// Only the cards registered with DRM are found, the vfio bound
+	// 0000:c1:00.0 has no DRM card.
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="0x038000",device_id="0x740c",gpu_id="0000:84:00.0",iommu_group="40",minor="1",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_vendor_id="0x1002",unique_id="8f2c3a1d5e7b9147",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorSiblingFunctions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030000", "vendor": "0x10de", "device": "0x2684"})
+	// HDMI audio function of the same card.
+	writeTestGPUDevice(t, dir, "0000:17:00.1", "", map[string]string{"class": "0x040300", "vendor": "0x10de", "device": "0x22ba"})
+	// Another card.
+	writeTestGPUDevice(t, dir, "0000:18:00.0", "", map[string]string{"class": "0x020000", "vendor": "0x8086", "device": "0x1521"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_function_info Other PCI functions of the GPU's card, such as HDMI audio, value is always 1.
+# TYPE node_gpu_function_info gauge
+node_gpu_function_info{function_class="0x040300",function_id="0000:17:00.1",gpu_id="0000:17:00.0"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_function_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorAudioFunctionPresent(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030000", "vendor": "0x10de", "device": "0x2684"})
+	writeTestGPUDevice(t, dir, "0000:17:00.1", "", map[string]string{"class": "0x040300", "vendor": "0x10de", "device": "0x22ba"})
+	// Consumer card missing its audio function.
+	writeTestGPUDevice(t, dir, "0000:18:00.0", "nvidia", map[string]string{"class": "0x030000", "vendor": "0x10de", "device": "0x2684"})
+	// Data center card without display outputs.
+	writeTestGPUDevice(t, dir, "0000:19:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	// Intel GPU, its display audio is on the chipset.
+	writeTestGPUDevice(t, dir, "0000:00:02.0", "i915", map[string]string{"class": "0x030000", "vendor": "0x8086", "device": "0xa780"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_audio_function_present Whether the GPU's card exposes its HDMI/DisplayPort audio function (0/1), -1 for GPUs not expected to have one: those that aren't VGA controllers, e.g. data center cards without display outputs, and Intel GPUs.
+# TYPE node_gpu_audio_function_present gauge
+node_gpu_audio_function_present{gpu_id="0000:00:02.0"} -1
+node_gpu_audio_function_present{gpu_id="0000:17:00.0"} 1
+node_gpu_audio_function_present{gpu_id="0000:18:00.0"} 0
+node_gpu_audio_function_present{gpu_id="0000:19:00.0"} -1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_audio_function_present"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorTopN(t *testing.T) {
+	dir := t.TempDir()
+	for name, temp := range map[string]string{
+		"0000:03:00.0": "52000",
+		"0000:23:00.0": "81000",
+		"0000:43:00.0": "67000",
+		// No temperature reading, ranks last.
+		"0000:63:00.0": "",
+	} {
+		files := map[string]string{"class": "0x038000", "vendor": "0x1002", "device": "0x740c"}
+		if temp != "" {
+			files[filepath.Join("hwmon", "hwmon0", "temp1_input")] = temp
+			files[filepath.Join("hwmon", "hwmon0", "temp1_label")] = "edge"
+		}
+		writeTestGPUDevice(t, dir, name, "amdgpu", files)
+	}
+
+	*gpuSysfsPath = dir
+	*gpuTopN = 2
+	defer func() {
+		*gpuSysfsPath = "bus/pci/devices"
+		*gpuTopN = 0
+	}()
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:23:00.0",sensor="edge"} 81
+node_gpu_temperature_celsius{gpu_id="0000:43:00.0",sensor="edge"} 67
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_temperature_celsius"); err != nil {
+		t.Fatal(err)
+	}
+	// node_gpu_info still covers all GPUs.
+	if n, err := testutil.GatherAndCount(reg, "node_gpu_info"); err != nil || n != 4 {
+		t.Errorf("got %d node_gpu_info series (err %v), want 4", n, err)
+	}
+}
+
+// writeTestGPUSkipDevices creates a display controller for each reason of
+// probeGPU to skip a device, next to a network card and a bound GPU, and
+// returns the GPU sysfs path.
+func writeTestGPUSkipDevices(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	// BMC graphics.
+	writeTestGPUDevice(t, dir, "0000:02:00.0", "", map[string]string{"class": "0x030000", "vendor": "0x1a03", "device": "0x2000"})
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	// No driver bound.
+	writeTestGPUDevice(t, dir, "0000:18:00.0", "", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	// Unsupported vendor.
+	writeTestGPUDevice(t, dir, "0000:19:00.0", "", map[string]string{"class": "0x030000", "vendor": "0x1234", "device": "0x1111"})
+	writeTestGPUDevice(t, dir, "0000:1a:00.0", "", map[string]string{"class": "0x030200"})
+	// The class can't be read, e.g. in a restricted container.
+	writeTestGPUDevice(t, dir, "0000:1b:00.0", "", map[string]string{"vendor": "0x10de", "device": "0x2330"})
+	writeTestGPUDevice(t, dir, "0000:3b:00.0", "", map[string]string{"class": "0x020000", "vendor": "0x15b3", "device": "0x101d"})
+	return dir
+}
+
+func TestGPUProbeSkipReasons(t *testing.T) {
+	dir := writeTestGPUSkipDevices(t)
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	probes, err := newTestGPUCollector(t).probeAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, probe := range probes {
+		got[probe.gpu.busID] = probe.skipReason
+	}
+	want := map[string]string{
+		"0000:02:00.0": gpuSkipBMC,
+		"0000:17:00.0": "",
+		"0000:18:00.0": gpuSkipNoDriver,
+		"0000:19:00.0": gpuSkipVendor,
+		"0000:1a:00.0": gpuSkipUnreadable,
+		"0000:1b:00.0": gpuSkipUnreadable,
+		"0000:3b:00.0": gpuSkipNotDisplay,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got skip reasons %v, want %v", got, want)
+	}
+}
+
+func TestGPULogValidation(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:03:00.0", "amdgpu", map[string]string{"class": "0x030000", "vendor": "0x1002", "device": "0x73bf", "mem_info_vram_total": "536870912"})
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330", "drm/renderD128": ""})
+	writeTestGPUDevice(t, dir, "0000:17:00.1", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330", "drm/renderD129": ""})
+	writeTestGPUDevice(t, dir, "0000:18:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	c := newTestGPUCollector(t)
+	var logs bytes.Buffer
+	c.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	c.minVRAM = 1 << 30
+	c.requireRenderNode = true
+	c.perCard = true
+	c.logValidation()
+
+	got := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		_, device, _ := strings.Cut(line, " device=")
+		device, _, _ = strings.Cut(device, " ")
+		_, reason, _ := strings.Cut(line, " skip_reason=")
+		got[device] = strings.Trim(reason, `"`)
+	}
+	want := map[string]string{
+		"0000:03:00.0": gpuSkipVRAM,
+		"0000:17:00.0": "none",
+		"0000:17:00.1": gpuSkipPerCard,
+		"0000:18:00.0": gpuSkipNoRenderNode,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got skip reasons %v, want %v", got, want)
+	}
+}
+
+func TestGPUCollectorFilteredTotal(t *testing.T) {
+	dir := writeTestGPUSkipDevices(t)
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	// Keep the fixtures' DRM cards from being picked up as fallback.
+	origSysPath := *sysPath
+	*sysPath = t.TempDir()
+	defer func() { *sysPath = origSysPath }()
+	c, err := NewGPUCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	// Only the bound NVIDIA card passes. The network card isn't a display
+	// controller and devices whose IDs can't be read aren't counted.
+	expected := `# HELP node_gpu_filtered_total Number of display controllers skipped by the GPU scan: bmc for BMC graphics, unknown_vendor for unsupported vendors and no_driver for GPUs without a driver bound.
+# TYPE node_gpu_filtered_total gauge
+node_gpu_filtered_total{reason="bmc"} 1
+node_gpu_filtered_total{reason="no_driver"} 1
+node_gpu_filtered_total{reason="unknown_vendor"} 1
+# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x030200",device_id="0x2330",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="NVIDIA H100-PCIE",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_filtered_total", "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorIncludeUnbound(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	// Enumerated, but the driver failed to probe it.
+	writeTestGPUDevice(t, dir, "0000:18:00.0", "", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+
+	// Unbound GPUs are skipped by default.
+	if got, err := testutil.GatherAndCount(newTestGPURegistry(t), "node_gpu_info"); err != nil || got != 1 {
+		t.Fatalf("got %d GPUs (err %v), want 1", got, err)
+	}
+
+	*gpuIncludeUnbound = true
+	t.Cleanup(func() { *gpuIncludeUnbound = false })
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_driver_bound Whether a GPU driver is bound to the GPU (0/1).
+# TYPE node_gpu_driver_bound gauge
+node_gpu_driver_bound{gpu_id="0000:17:00.0"} 1
+node_gpu_driver_bound{gpu_id="0000:18:00.0"} 0
+# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x030200",device_id="0x2330",driver="nvidia",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="NVIDIA H100-PCIE",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+node_gpu_info{class_name="0x030200",device_id="0x2330",driver="",gpu_id="0000:18:00.0",iommu_group="-1",minor="",model="NVIDIA H100-PCIE",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_driver_bound", "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorClassName(t *testing.T) {
+	dir := t.TempDir()
+	// Compute-only card.
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	writeTestGPUDevice(t, dir, "0000:83:00.0", "amdgpu", map[string]string{"class": "0x030000", "vendor": "0x1002", "device": "0x7550"})
+	// Subclass missing from pci.ids, named after the base class.
+	writeTestGPUDevice(t, dir, "0000:84:00.0", "amdgpu", map[string]string{"class": "0x030100", "vendor": "0x1002", "device": "0x7550"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+
+	c := newTestGPUCollector(t)
+	c.pciProvider = newPCIIDProvider(c.logger, nil, "fixtures/pci.ids", "", false, 0)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="3D controller",device_id="0x2330",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="NVIDIA H100-PCIE",subsystem_device_id="",subsystem_device_name="",subsystem_vendor_id="",subsystem_vendor_name="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+node_gpu_info{class_name="Display controller",device_id="0x7550",gpu_id="0000:84:00.0",iommu_group="-1",minor="",model="0x7550",subsystem_device_id="",subsystem_device_name="",subsystem_vendor_id="",subsystem_vendor_name="",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="VGA compatible controller",device_id="0x7550",gpu_id="0000:83:00.0",iommu_group="-1",minor="",model="0x7550",subsystem_device_id="",subsystem_device_name="",subsystem_vendor_id="",subsystem_vendor_name="",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorSubsystemNames(t *testing.T) {
+	c := newTestGPUCollector(t)
+	c.pciProvider = newPCIIDProvider(c.logger, nil, "fixtures/pci.ids", "", false, 0)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="Display controller",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="40",minor="0",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_device_name="Instinct MI250X",subsystem_vendor_id="0x1002",subsystem_vendor_name="Advanced Micro Devices, Inc. [AMD/ATI]",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="Display controller",device_id="0x740c",gpu_id="0000:84:00.0",iommu_group="40",minor="1",model="AMD Instinct MI250X/MI250",subsystem_device_id="0x0b0c",subsystem_device_name="Instinct MI250X",subsystem_vendor_id="0x1002",subsystem_vendor_name="Advanced Micro Devices, Inc. [AMD/ATI]",unique_id="8f2c3a1d5e7b9147",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{class_name="Display controller",device_id="0x740f",gpu_id="0000:c1:00.0",iommu_group="62",minor="",model="AMD Instinct MI210",subsystem_device_id="0x0c34",subsystem_device_name="Instinct MI210",subsystem_vendor_id="0x1002",subsystem_vendor_name="Advanced Micro Devices, Inc. [AMD/ATI]",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorPerCard(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "amdgpu", map[string]string{"class": "0x030000", "vendor": "0x1002", "device": "0x7550"})
+	// Secondary display function of the same card.
+	writeTestGPUDevice(t, dir, "0000:17:00.1", "amdgpu", map[string]string{"class": "0x038000", "vendor": "0x1002", "device": "0x7550"})
+	// HDMI audio function of the same card.
+	writeTestGPUDevice(t, dir, "0000:17:00.2", "amdgpu", map[string]string{"class": "0x040300", "vendor": "0x1002", "device": "0xab40"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+
+	// One series per display function by default.
+	if got, err := testutil.GatherAndCount(newTestGPURegistry(t), "node_gpu_info"); err != nil || got != 2 {
+		t.Fatalf("got %d GPUs (err %v), want 2", got, err)
+	}
+
+	*gpuPerCard = true
+	t.Cleanup(func() { *gpuPerCard = false })
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_cards_total Total number of GPU cards detected.
+# TYPE node_gpu_cards_total gauge
+node_gpu_cards_total{model="0x7550"} 1
+# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x030000",device_id="0x7550",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="0x7550",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_cards_total", "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorMixedVendors(t *testing.T) {
+	// The fixture GPUs are all AMD.
+	expected := `# HELP node_gpu_mixed_vendors Whether the node has GPUs from more than one vendor (0/1).
+# TYPE node_gpu_mixed_vendors gauge
+node_gpu_mixed_vendors 0
+`
+	if err := testutil.GatherAndCompare(newTestGPURegistry(t), strings.NewReader(expected), "node_gpu_mixed_vendors"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	// NVIDIA compute card next to an AMD display adapter.
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+	writeTestGPUDevice(t, dir, "0000:65:00.0", "amdgpu", map[string]string{"class": "0x030000", "vendor": "0x1002", "device": "0x164e"})
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+
+	expected = `# HELP node_gpu_mixed_vendors Whether the node has GPUs from more than one vendor (0/1).
+# TYPE node_gpu_mixed_vendors gauge
+node_gpu_mixed_vendors 1
+`
+	if err := testutil.GatherAndCompare(newTestGPURegistry(t), strings.NewReader(expected), "node_gpu_mixed_vendors"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorDriverVersionMismatch(t *testing.T) {
+	// writeNVIDIAGPUs creates an NVIDIA GPU bound to a driver of each of the
+	// given module versions.
+	writeNVIDIAGPUs := func(versions ...string) string {
+		dir := t.TempDir()
+		for i, version := range versions {
+			path := writeTestGPUDevice(t, dir, fmt.Sprintf("0000:%02x:00.0", 0x17+i), "", map[string]string{"class": "0x030200", "vendor": "0x10de", "device": "0x2330"})
+			module := filepath.Join(t.TempDir(), "module", "nvidia")
+			driver := filepath.Join(filepath.Dir(filepath.Dir(module)), "drivers", "nvidia")
+			for _, d := range []string{module, driver} {
+				if err := os.MkdirAll(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(module, "version"), []byte(version+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(module, filepath.Join(driver, "module")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(driver, filepath.Join(path, "driver")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+
+	for _, tc := range []struct {
+		name     string
+		versions []string
+		expected string
+	}{
+		{
+			name:     "mismatch",
+			versions: []string{"550.54.15", "535.161.08"},
+			expected: `# HELP node_gpu_driver_version_mismatch Whether GPUs of the same vendor run different driver versions (0/1).
+# TYPE node_gpu_driver_version_mismatch gauge
+node_gpu_driver_version_mismatch 1
+`,
+		},
+		{
+			name:     "consistent",
+			versions: []string{"550.54.15", "550.54.15"},
+			expected: `# HELP node_gpu_driver_version_mismatch Whether GPUs of the same vendor run different driver versions (0/1).
+# TYPE node_gpu_driver_version_mismatch gauge
+node_gpu_driver_version_mismatch 0
+`,
+		},
+		{
+			name:     "single GPU",
+			versions: []string{"550.54.15"},
+			expected: "",
+		},
+	} {
+		*gpuSysfsPath = writeNVIDIAGPUs(tc.versions...)
+		reg := newTestGPURegistry(t)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(tc.expected), "node_gpu_driver_version_mismatch"); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestGPUCollectorVanishingDevice(t *testing.T) {
+	dir := t.TempDir()
+	device, err := filepath.Abs("fixtures/sys/devices/pci0000:c0/0000:c1:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(device, filepath.Join(dir, "0000:c1:00.0")); err != nil {
+		t.Fatal(err)
+	}
+	// A device removed after bus/pci/devices was listed: its link dangles.
+	gone := filepath.Join(t.TempDir(), "0000:99:00.0")
+	if err := os.Mkdir(gone, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(gone, filepath.Join(dir, "0000:99:00.0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	// A device removed while its attributes were being read.
+	if err := os.Mkdir(filepath.Join(dir, "0000:98:00.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	*sysPath = "fixtures/sys"
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	var logs bytes.Buffer
+	c, err := NewGPUCollector(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x038000",device_id="0x740f",gpu_id="0000:c1:00.0",iommu_group="62",minor="",model="AMD Instinct MI210",subsystem_device_id="0x0c34",subsystem_vendor_id="0x1002",unique_id="",vendor="AMD/ATI",vendor_id="0x1002"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+	for _, busID := range []string{"0000:98:00.0", "0000:99:00.0"} {
+		if strings.Contains(logs.String(), busID) {
+			t.Errorf("vanished device %s was logged:\n%s", busID, logs.String())
+		}
+	}
+}
+
+func TestCapGPUModels(t *testing.T) {
+	counts := map[string]int{
+		"NVIDIA H100-PCIE":   4,
+		"NVIDIA A100-PCIE":   2,
+		"NVIDIA L4":          2,
+		"0x2bff":             1,
+		"AMD Instinct MI210": 1,
+	}
+	for limit, want := range map[int]map[string]int{
+		0: counts,
+		5: counts,
+		3: {"NVIDIA H100-PCIE": 4, "NVIDIA A100-PCIE": 2, "other": 4},
+		1: {"other": 10},
+	} {
+		if got := capGPUModels(counts, limit); !maps.Equal(got, want) {
+			t.Errorf("limit %d: got %v, want %v", limit, got, want)
+		}
+	}
+}
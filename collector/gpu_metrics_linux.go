@@ -0,0 +1,215 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// amdgpu's gpu_metrics file is a binary dump of one of the gpu_metrics_vX_Y
+// structs of the kernel's kgd_pp_interface.h, all starting with a
+// metrics_table_header:
+//
+//	struct metrics_table_header {
+//		uint16_t structure_size;
+//		uint8_t  format_revision;
+//		uint8_t  content_revision;
+//	};
+//
+// Format 1 is used by discrete GPUs, format 2 by APUs. The offsets below
+// follow the natural alignment of the structs. Only revisions whose layout
+// is known are parsed:
+//
+//   - v1.1 to v1.3 share their first 56 bytes: edge, hotspot and memory
+//     temperatures in Celsius at 4, average socket power in watts at 22 and
+//     average gfx, soc and memory clocks in MHz at 40. v1.3 appends the
+//     voltages and the ASIC independent indep_throttle_status at 112.
+//   - v2.0 to v2.2 share their first 120 bytes: gfx and soc temperatures in
+//     centi-Celsius at 16, average socket power in milliwatts at 44 and
+//     average gfx, soc and memory clocks in MHz at 68. v2.2 appends
+//     indep_throttle_status at 128.
+//
+// The ASIC dependent throttle_status both formats have earlier on is not
+// used, its bits differ between GPU generations.
+//
+// Fields the firmware doesn't fill are set to all ones.
+type gpuMetricsLayout struct {
+	temperatures     []gpuMetricsField
+	temperatureScale float64
+	power            int
+	powerScale       float64
+	clocks           []gpuMetricsField
+	// throttle is the offset of indep_throttle_status, 0 if not present.
+	throttle int
+}
+
+type gpuMetricsField struct {
+	name   string
+	offset int
+}
+
+var (
+	gpuMetricsV1Layout = gpuMetricsLayout{
+		temperatures:     []gpuMetricsField{{"edge", 4}, {"hotspot", 6}, {"mem", 8}},
+		temperatureScale: 1,
+		power:            22,
+		powerScale:       1,
+		clocks:           []gpuMetricsField{{gpuClockGraphics, 40}, {gpuClockSoC, 42}, {gpuClockMemory, 44}},
+	}
+	gpuMetricsV13Layout = func() gpuMetricsLayout {
+		l := gpuMetricsV1Layout
+		l.throttle = 112
+		return l
+	}()
+	gpuMetricsV2Layout = gpuMetricsLayout{
+		temperatures:     []gpuMetricsField{{"gfx", 16}, {"soc", 18}},
+		temperatureScale: 0.01,
+		power:            44,
+		powerScale:       0.001,
+		clocks:           []gpuMetricsField{{gpuClockGraphics, 68}, {gpuClockSoC, 70}, {gpuClockMemory, 72}},
+	}
+	gpuMetricsV22Layout = func() gpuMetricsLayout {
+		l := gpuMetricsV2Layout
+		l.throttle = 128
+		return l
+	}()
+)
+
+// gpuMetricsLayouts maps format and content revision to the struct layout.
+var gpuMetricsLayouts = map[[2]uint8]gpuMetricsLayout{
+	{1, 1}: gpuMetricsV1Layout,
+	{1, 2}: gpuMetricsV1Layout,
+	{1, 3}: gpuMetricsV13Layout,
+	{2, 0}: gpuMetricsV2Layout,
+	{2, 1}: gpuMetricsV2Layout,
+	{2, 2}: gpuMetricsV22Layout,
+}
+
+// gpuThermalThrottleMask selects the temperature related bits of
+// indep_throttle_status.
+const gpuThermalThrottleMask = 0x0000_ffff_0000_0000
+
+// The indep_throttle_status bits are grouped by cause, see the
+// SMU_THROTTLER_*_BIT definitions of amdgpu_smu.h.
+var gpuThrottleReasons = []struct {
+	reason string
+	mask   uint64
+}{
+	{"power", 0x0000_0000_0000_ffff},
+	{"current", 0x0000_0000_ffff_0000},
+	{"thermal", gpuThermalThrottleMask},
+}
+
+var errGPUMetricsVersion = errors.New("unsupported gpu_metrics version")
+
+// gpuMetrics holds the values read from an amdgpu gpu_metrics file. Values
+// the firmware doesn't report are left out.
+type gpuMetrics struct {
+	// temperatures in Celsius by sensor.
+	temperatures map[string]float64
+	// power is the average socket power in watts, -1 if unknown.
+	power float64
+	// clocks are the average clock frequencies in hertz by domain.
+	clocks map[string]float64
+	// throttleStatus is indep_throttle_status, nil if not reported.
+	throttleStatus *uint64
+}
+
+// parseGPUMetrics parses an amdgpu gpu_metrics blob.
+func parseGPUMetrics(data []byte) (gpuMetrics, error) {
+	if len(data) < 4 {
+		return gpuMetrics{}, fmt.Errorf("gpu_metrics too short: %d bytes", len(data))
+	}
+	format, content := data[2], data[3]
+	layout, ok := gpuMetricsLayouts[[2]uint8{format, content}]
+	if !ok {
+		return gpuMetrics{}, fmt.Errorf("%w v%d.%d", errGPUMetricsVersion, format, content)
+	}
+	// Never read beyond the size the kernel reports.
+	if size := int(binary.LittleEndian.Uint16(data[0:])); size < len(data) {
+		data = data[:size]
+	}
+
+	readUint16 := func(offset int) (uint16, bool) {
+		if offset+2 > len(data) {
+			return 0, false
+		}
+		v := binary.LittleEndian.Uint16(data[offset:])
+		return v, v != 0xffff
+	}
+
+	m := gpuMetrics{
+		temperatures: make(map[string]float64),
+		power:        -1,
+		clocks:       make(map[string]float64),
+	}
+	for _, f := range layout.temperatures {
+		if v, ok := readUint16(f.offset); ok {
+			m.temperatures[f.name] = float64(v) * layout.temperatureScale
+		}
+	}
+	if v, ok := readUint16(layout.power); ok {
+		m.power = float64(v) * layout.powerScale
+	}
+	for _, f := range layout.clocks {
+		if v, ok := readUint16(f.offset); ok {
+			m.clocks[f.name] = float64(v) * 1e6
+		}
+	}
+	if layout.throttle != 0 && layout.throttle+8 <= len(data) {
+		status := binary.LittleEndian.Uint64(data[layout.throttle:])
+		m.throttleStatus = &status
+	}
+	return m, nil
+}
+
+// readGPUMetrics reads and parses the gpu_metrics file of an amdgpu card.
+// ok is false for other GPUs and unrecognized gpu_metrics versions.
+func (c *gpuCollector) readGPUMetrics(gpu gpuDevice) (m gpuMetrics, ok bool) {
+	if gpu.vendorID != vendorAMD {
+		return gpuMetrics{}, false
+	}
+	data, err := os.ReadFile(filepath.Join(gpu.path, "gpu_metrics"))
+	if err != nil {
+		return gpuMetrics{}, false
+	}
+	m, err = parseGPUMetrics(data)
+	if err != nil {
+		if !errors.Is(err, errGPUMetricsVersion) {
+			c.logger.Debug("Failed to parse gpu_metrics", "busID", gpu.busID, "error", err)
+		}
+		return gpuMetrics{}, false
+	}
+	return m, true
+}
+
+// sensorReadings returns the temperatures, power and clocks of m, the power
+// being that of the whole socket.
+func (m gpuMetrics) sensorReadings() gpuSensorReadings {
+	r := gpuSensorReadings{
+		temperatures:  m.temperatures,
+		clocks:        m.clocks,
+		averageClocks: true,
+	}
+	if m.power >= 0 {
+		r.power = map[string]float64{"socket": m.power}
+	}
+	return r
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newGPUMetricsV13 returns a gpu_metrics_v1_3 blob of a discrete GPU at
+// 45C edge, 60C hotspot, memory temperature not reported, drawing 220W, with
+// the given indep_throttle_status.
+func newGPUMetricsV13(indepThrottleStatus uint64) []byte {
+	data := make([]byte, 120)
+	binary.LittleEndian.PutUint16(data[0:], uint16(len(data)))
+	data[2], data[3] = 1, 3
+	binary.LittleEndian.PutUint16(data[4:], 45)
+	binary.LittleEndian.PutUint16(data[6:], 60)
+	binary.LittleEndian.PutUint16(data[8:], 0xffff)
+	binary.LittleEndian.PutUint16(data[22:], 220)
+	binary.LittleEndian.PutUint16(data[40:], 1700)
+	binary.LittleEndian.PutUint16(data[42:], 1000)
+	binary.LittleEndian.PutUint16(data[44:], 1600)
+	// ASIC dependent throttle_status, must be ignored.
+	binary.LittleEndian.PutUint32(data[68:], 0xffffffff)
+	binary.LittleEndian.PutUint64(data[112:], indepThrottleStatus)
+	return data
+}
+
+// newGPUMetricsV22 returns a gpu_metrics_v2_2 blob of an APU at 52.5C gfx
+// and 48C soc, drawing 15.5W, with the given indep_throttle_status.
+func newGPUMetricsV22(indepThrottleStatus uint64) []byte {
+	data := make([]byte, 136)
+	binary.LittleEndian.PutUint16(data[0:], uint16(len(data)))
+	data[2], data[3] = 2, 2
+	binary.LittleEndian.PutUint16(data[16:], 5250)
+	binary.LittleEndian.PutUint16(data[18:], 4800)
+	binary.LittleEndian.PutUint16(data[44:], 15500)
+	binary.LittleEndian.PutUint16(data[68:], 2200)
+	binary.LittleEndian.PutUint16(data[70:], 1200)
+	binary.LittleEndian.PutUint16(data[72:], 0xffff)
+	// ASIC dependent throttle_status, must be ignored.
+	binary.LittleEndian.PutUint32(data[112:], 0xffffffff)
+	binary.LittleEndian.PutUint64(data[128:], indepThrottleStatus)
+	return data
+}
+
+func TestParseGPUMetrics(t *testing.T) {
+	// PROCHOT_GFX.
+	v1, err := parseGPUMetrics(newGPUMetricsV13(1 << 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"edge": 45, "hotspot": 60}; !maps.Equal(v1.temperatures, want) {
+		t.Errorf("v1.3: got temperatures %v, want %v", v1.temperatures, want)
+	}
+	if v1.power != 220 {
+		t.Errorf("v1.3: got power %v, want 220", v1.power)
+	}
+	if want := map[string]float64{"graphics": 1.7e9, "soc": 1e9, "memory": 1.6e9}; !maps.Equal(v1.clocks, want) {
+		t.Errorf("v1.3: got clocks %v, want %v", v1.clocks, want)
+	}
+	if want := uint64(1 << 16); v1.throttleStatus == nil || *v1.throttleStatus != want {
+		t.Errorf("v1.3: got throttle status %v, want %#x", v1.throttleStatus, want)
+	}
+
+	// PPT0 and TEMP_CORE.
+	v2, err := parseGPUMetrics(newGPUMetricsV22(1<<0 | 1<<33))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"gfx": 52.5, "soc": 48}; !maps.Equal(v2.temperatures, want) {
+		t.Errorf("v2.2: got temperatures %v, want %v", v2.temperatures, want)
+	}
+	if v2.power != 15.5 {
+		t.Errorf("v2.2: got power %v, want 15.5", v2.power)
+	}
+	if want := map[string]float64{"graphics": 2.2e9, "soc": 1.2e9}; !maps.Equal(v2.clocks, want) {
+		t.Errorf("v2.2: got clocks %v, want %v", v2.clocks, want)
+	}
+	if want := uint64(1<<0 | 1<<33); v2.throttleStatus == nil || *v2.throttleStatus != want {
+		t.Errorf("v2.2: got throttle status %v, want %#x", v2.throttleStatus, want)
+	}
+
+	// v1.0 has a different layout.
+	v10 := newGPUMetricsV13(0)
+	v10[3] = 0
+	if _, err := parseGPUMetrics(v10); !errors.Is(err, errGPUMetricsVersion) {
+		t.Errorf("v1.0: want errGPUMetricsVersion, got %v", err)
+	}
+	if _, err := parseGPUMetrics([]byte{0x04, 0x00}); err == nil {
+		t.Error("expected error for truncated gpu_metrics")
+	}
+}
+
+func TestGPUCollectorGPUMetrics(t *testing.T) {
+	dir := t.TempDir()
+	for busID, metrics := range map[string][]byte{
+		// Thermal (TEMP_EDGE) throttling.
+		"0000:03:00.0": newGPUMetricsV13(1 << 32),
+		// Power (PPT0) and thermal (TEMP_CORE) throttling.
+		"0000:04:00.0": newGPUMetricsV22(1<<0 | 1<<33),
+		// Unrecognized version.
+		"0000:05:00.0": {0x78, 0x00, 0x03, 0x00},
+	} {
+		writeTestGPUDevice(t, dir, busID, "amdgpu", map[string]string{
+			"class":       "0x030000",
+			"vendor":      vendorAMD,
+			"device":      "0x1681",
+			"gpu_metrics": string(metrics),
+		})
+	}
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	reg := newTestGPURegistry(t)
+
+	expected := `# HELP node_gpu_average_clock_hertz Average clock frequency of the GPU per clock domain from amdgpu gpu_metrics.
+# TYPE node_gpu_average_clock_hertz gauge
+node_gpu_average_clock_hertz{clock="graphics",gpu_id="0000:03:00.0"} 1.7e+09
+node_gpu_average_clock_hertz{clock="graphics",gpu_id="0000:04:00.0"} 2.2e+09
+node_gpu_average_clock_hertz{clock="memory",gpu_id="0000:03:00.0"} 1.6e+09
+node_gpu_average_clock_hertz{clock="soc",gpu_id="0000:03:00.0"} 1e+09
+node_gpu_average_clock_hertz{clock="soc",gpu_id="0000:04:00.0"} 1.2e+09
+# HELP node_gpu_power_watts Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.
+# TYPE node_gpu_power_watts gauge
+node_gpu_power_watts{gpu_id="0000:03:00.0",rail="socket"} 220
+node_gpu_power_watts{gpu_id="0000:04:00.0",rail="socket"} 15.5
+# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="edge"} 45
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="hotspot"} 60
+node_gpu_temperature_celsius{gpu_id="0000:04:00.0",sensor="gfx"} 52.5
+node_gpu_temperature_celsius{gpu_id="0000:04:00.0",sensor="soc"} 48
+# HELP node_gpu_throttle_status Whether the GPU is throttled for the given reason according to amdgpu gpu_metrics (0/1).
+# TYPE node_gpu_throttle_status gauge
+node_gpu_throttle_status{gpu_id="0000:03:00.0",reason="current"} 0
+node_gpu_throttle_status{gpu_id="0000:03:00.0",reason="power"} 0
+node_gpu_throttle_status{gpu_id="0000:03:00.0",reason="thermal"} 1
+node_gpu_throttle_status{gpu_id="0000:04:00.0",reason="current"} 0
+node_gpu_throttle_status{gpu_id="0000:04:00.0",reason="power"} 1
+node_gpu_throttle_status{gpu_id="0000:04:00.0",reason="thermal"} 1
+`
+	metrics := []string{"node_gpu_average_clock_hertz", "node_gpu_power_watts", "node_gpu_temperature_celsius", "node_gpu_throttle_status"}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), metrics...); err != nil {
+		t.Fatal(err)
+	}
+}
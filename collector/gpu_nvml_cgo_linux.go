@@ -0,0 +1,263 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && cgo
+
+package collector
+
+import (
+	"slices"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+type nvmlLib struct{}
+
+type nvmlDev struct {
+	dev nvml.Device
+}
+
+// newNVMLLibrary loads libnvidia-ml and initializes NVML.
+func newNVMLLibrary() (nvmlLibrary, error) {
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	return nvmlLib{}, nil
+}
+
+// nvmlError converts an NVML return code into an error.
+func nvmlError(ret nvml.Return) error {
+	switch ret {
+	case nvml.SUCCESS:
+		return nil
+	case nvml.ERROR_NOT_SUPPORTED:
+		return errNVMLNotSupported
+	default:
+		return ret
+	}
+}
+
+func (nvmlLib) DeviceByBusID(busID string) (nvmlDevice, error) {
+	dev, ret := nvml.DeviceGetHandleByPciBusId(busID)
+	if ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	return nvmlDev{dev: dev}, nil
+}
+
+func (d nvmlDev) MemoryTotal() (uint64, error) {
+	mem, ret := d.dev.GetMemoryInfo()
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return mem.Total, nil
+}
+
+func (d nvmlDev) ViolationTime(policy nvmlPerfPolicy) (uint64, error) {
+	v, ret := d.dev.GetViolationStatus(nvml.PerfPolicyType(policy))
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return v.ViolationTime, nil
+}
+
+func (d nvmlDev) Temperature() (uint32, error) {
+	temp, ret := d.dev.GetTemperature(nvml.TEMPERATURE_GPU)
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return temp, nil
+}
+
+func (d nvmlDev) PowerUsage() (uint32, error) {
+	mw, ret := d.dev.GetPowerUsage()
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return mw, nil
+}
+
+func (d nvmlDev) ClockInfo(clock nvmlClockType) (uint32, error) {
+	mhz, ret := d.dev.GetClockInfo(nvml.ClockType(clock))
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return mhz, nil
+}
+
+func (d nvmlDev) MaxClockInfo(clock nvmlClockType) (uint32, error) {
+	mhz, ret := d.dev.GetMaxClockInfo(nvml.ClockType(clock))
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return mhz, nil
+}
+
+func (d nvmlDev) Utilization() (uint32, error) {
+	rates, ret := d.dev.GetUtilizationRates()
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return rates.Gpu, nil
+}
+
+func (d nvmlDev) EccMode() (bool, bool, error) {
+	current, pending, ret := d.dev.GetEccMode()
+	if ret != nvml.SUCCESS {
+		return false, false, nvmlError(ret)
+	}
+	return current == nvml.FEATURE_ENABLED, pending == nvml.FEATURE_ENABLED, nil
+}
+
+func (d nvmlDev) Name() (string, error) {
+	name, ret := d.dev.GetName()
+	if ret != nvml.SUCCESS {
+		return "", nvmlError(ret)
+	}
+	return name, nil
+}
+
+func (d nvmlDev) BoardPartNumber() (string, error) {
+	partNumber, ret := d.dev.GetBoardPartNumber()
+	if ret != nvml.SUCCESS {
+		return "", nvmlError(ret)
+	}
+	return partNumber, nil
+}
+
+func (d nvmlDev) UUID() (string, error) {
+	uuid, ret := d.dev.GetUUID()
+	if ret != nvml.SUCCESS {
+		return "", nvmlError(ret)
+	}
+	return uuid, nil
+}
+
+func (d nvmlDev) RemappedRows() (bool, bool, error) {
+	_, _, pending, failure, ret := d.dev.GetRemappedRows()
+	if ret != nvml.SUCCESS {
+		return false, false, nvmlError(ret)
+	}
+	return pending, failure, nil
+}
+
+func (d nvmlDev) Serial() (string, error) {
+	serial, ret := d.dev.GetSerial()
+	if ret != nvml.SUCCESS {
+		return "", nvmlError(ret)
+	}
+	return serial, nil
+}
+
+func (d nvmlDev) MinorNumber() (int, error) {
+	minor, ret := d.dev.GetMinorNumber()
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return minor, nil
+}
+
+func (d nvmlDev) RunningProcesses() ([]int, error) {
+	compute, ret := d.dev.GetComputeRunningProcesses()
+	if ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	graphics, ret := d.dev.GetGraphicsRunningProcesses()
+	if ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	// A process using the GPU for both is listed twice.
+	var pids []int
+	for _, p := range append(compute, graphics...) {
+		if !slices.Contains(pids, int(p.Pid)) {
+			pids = append(pids, int(p.Pid))
+		}
+	}
+	return pids, nil
+}
+
+func (d nvmlDev) ConfComputeEnabled() (bool, error) {
+	// The protected memory size is answered by GPUs that cannot run in
+	// confidential computing mode too, so ask the system whether its GPUs
+	// are capable instead.
+	caps, ret := nvml.SystemGetConfComputeCapabilities()
+	if ret == nvml.ERROR_FUNCTION_NOT_FOUND || ret == nvml.ERROR_NOT_SUPPORTED {
+		return false, errNVMLNotSupported
+	}
+	if ret != nvml.SUCCESS {
+		return false, nvmlError(ret)
+	}
+	if caps.GpusCaps != nvml.CC_SYSTEM_GPUS_CC_CAPABLE {
+		return false, errNVMLNotSupported
+	}
+	state, ret := nvml.SystemGetConfComputeState()
+	if ret != nvml.SUCCESS {
+		return false, nvmlError(ret)
+	}
+	return state.CcFeature == nvml.CC_SYSTEM_FEATURE_ENABLED, nil
+}
+
+func (d nvmlDev) NvLinkState(link int) (bool, error) {
+	state, ret := d.dev.GetNvLinkState(link)
+	// Links beyond the number the device has are rejected as invalid.
+	if ret == nvml.ERROR_INVALID_ARGUMENT {
+		return false, errNVMLNotSupported
+	}
+	if ret != nvml.SUCCESS {
+		return false, nvmlError(ret)
+	}
+	return state == nvml.FEATURE_ENABLED, nil
+}
+
+func (d nvmlDev) NvLinkUtilizationUnit(link int) (nvmlNvLinkCounterUnit, error) {
+	control, ret := d.dev.GetNvLinkUtilizationControl(link, 0)
+	if ret != nvml.SUCCESS {
+		return 0, nvmlError(ret)
+	}
+	return nvmlNvLinkCounterUnit(control.Units), nil
+}
+
+func (d nvmlDev) NvLinkUtilization(link int) (uint64, uint64, error) {
+	rx, tx, ret := d.dev.GetNvLinkUtilizationCounter(link, 0)
+	if ret != nvml.SUCCESS {
+		return 0, 0, nvmlError(ret)
+	}
+	return rx, tx, nil
+}
+
+func (d nvmlDev) AccountingEnabled() (bool, error) {
+	mode, ret := d.dev.GetAccountingMode()
+	if ret != nvml.SUCCESS {
+		return false, nvmlError(ret)
+	}
+	return mode == nvml.FEATURE_ENABLED, nil
+}
+
+func (d nvmlDev) AccountingPids() ([]int, error) {
+	pids, ret := d.dev.GetAccountingPids()
+	if ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	return pids, nil
+}
+
+func (d nvmlDev) AccountingStats(pid int) (nvmlAccountingStats, error) {
+	stats, ret := d.dev.GetAccountingStats(uint32(pid))
+	if ret != nvml.SUCCESS {
+		return nvmlAccountingStats{}, nvmlError(ret)
+	}
+	return nvmlAccountingStats{
+		gpuUtilization: stats.GpuUtilization,
+		maxMemoryUsage: stats.MaxMemoryUsage,
+	}, nil
+}
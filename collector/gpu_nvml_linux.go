@@ -0,0 +1,370 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	gpuNVML = kingpin.Flag("collector.gpu.nvml", "Enable NVML-backed metrics for NVIDIA GPUs (requires libnvidia-ml).").Default("false").Bool()
+
+	gpuNVMLAccounting             = kingpin.Flag("collector.gpu.nvml.accounting", "Enable per-process NVML accounting metrics for GPUs with accounting mode enabled.").Default("false").Bool()
+	gpuNVMLAccountingMaxProcesses = kingpin.Flag("collector.gpu.nvml.accounting-max-processes", "Maximum number of accounted processes exposed per GPU.").Default("100").Int()
+
+	// errNVMLNotSupported is returned by nvmlDevice methods when the device
+	// does not support the requested query.
+	errNVMLNotSupported = errors.New("not supported by device")
+)
+
+// nvmlPerfPolicy mirrors nvmlPerfPolicyType_t.
+type nvmlPerfPolicy int
+
+const (
+	nvmlPerfPolicyPower       nvmlPerfPolicy = 0
+	nvmlPerfPolicyThermal     nvmlPerfPolicy = 1
+	nvmlPerfPolicyBoardLimit  nvmlPerfPolicy = 3
+	nvmlPerfPolicyReliability nvmlPerfPolicy = 5
+)
+
+// nvmlViolationPolicies maps the policy label to the NVML policy type.
+var nvmlViolationPolicies = []struct {
+	name   string
+	policy nvmlPerfPolicy
+}{
+	{"power", nvmlPerfPolicyPower},
+	{"thermal", nvmlPerfPolicyThermal},
+	{"board_limit", nvmlPerfPolicyBoardLimit},
+	{"reliability", nvmlPerfPolicyReliability},
+}
+
+// nvmlClockType mirrors nvmlClockType_t.
+type nvmlClockType int
+
+const (
+	nvmlClockGraphics nvmlClockType = 0
+	nvmlClockSM       nvmlClockType = 1
+	nvmlClockMem      nvmlClockType = 2
+)
+
+// nvmlClocks maps the clock label to the NVML clock type.
+var nvmlClocks = []struct {
+	name  string
+	clock nvmlClockType
+}{
+	{gpuClockGraphics, nvmlClockGraphics},
+	{gpuClockSM, nvmlClockSM},
+	{gpuClockMemory, nvmlClockMem},
+}
+
+// nvmlNvLinkMaxLinks mirrors NVML_NVLINK_MAX_LINKS.
+const nvmlNvLinkMaxLinks = 18
+
+// nvmlNvLinkCounterUnit mirrors nvmlNvLinkUtilizationCountUnits_t.
+type nvmlNvLinkCounterUnit int
+
+const (
+	nvmlNvLinkCounterUnitPackets nvmlNvLinkCounterUnit = 1
+	nvmlNvLinkCounterUnitBytes   nvmlNvLinkCounterUnit = 2
+)
+
+// nvmlAccountingStats holds the NVML accounting data of a process.
+type nvmlAccountingStats struct {
+	// gpuUtilization is the average GPU utilization over the lifetime of
+	// the process, in percent.
+	gpuUtilization uint32
+	// maxMemoryUsage is the peak framebuffer usage of the process in bytes.
+	maxMemoryUsage uint64
+}
+
+// nvmlLibrary is the subset of NVML used by the GPU collector.
+type nvmlLibrary interface {
+	DeviceByBusID(busID string) (nvmlDevice, error)
+}
+
+// nvmlDevice is the subset of the NVML device API used by the GPU collector.
+type nvmlDevice interface {
+	// Name returns the product name of the GPU.
+	Name() (string, error)
+	// MemoryTotal returns the installed framebuffer memory in bytes.
+	MemoryTotal() (uint64, error)
+	// ViolationTime returns the accumulated violation time in nanoseconds.
+	ViolationTime(policy nvmlPerfPolicy) (uint64, error)
+	// Temperature returns the GPU core temperature in degrees Celsius.
+	Temperature() (uint32, error)
+	// PowerUsage returns the power drawn by the whole board in milliwatts.
+	PowerUsage() (uint32, error)
+	// ClockInfo returns the current frequency of the given clock in MHz.
+	ClockInfo(clock nvmlClockType) (uint32, error)
+	// MaxClockInfo returns the highest frequency of the given clock in MHz.
+	MaxClockInfo(clock nvmlClockType) (uint32, error)
+	// Utilization returns the percent of the last sample period during
+	// which a kernel was running on the GPU.
+	Utilization() (uint32, error)
+	// EccMode returns whether ECC is currently enabled and whether it will
+	// be after the next reboot.
+	EccMode() (current, pending bool, err error)
+	// BoardPartNumber returns the OEM board part number.
+	BoardPartNumber() (string, error)
+	// UUID returns the globally unique immutable identifier of the GPU.
+	UUID() (string, error)
+	// Serial returns the serial number printed on the board.
+	Serial() (string, error)
+	// MinorNumber returns the minor number of the /dev/nvidia* device node.
+	MinorNumber() (int, error)
+	// NvLinkState returns whether the given NVLink is active.
+	NvLinkState(link int) (bool, error)
+	// NvLinkUtilizationUnit returns what utilization counter 0 of the
+	// given NVLink counts, as set by nvmlDeviceSetNvLinkUtilizationControl.
+	NvLinkUtilizationUnit(link int) (nvmlNvLinkCounterUnit, error)
+	// NvLinkUtilization returns the received and transmitted units of the
+	// given NVLink, read from utilization counter 0.
+	NvLinkUtilization(link int) (rx, tx uint64, err error)
+	// RemappedRows returns whether a row remapping is pending, applied on
+	// the next GPU reset, and whether a remapping failed. Only Ampere and
+	// later remap rows, errNVMLNotSupported is returned for older GPUs.
+	RemappedRows() (pending, failure bool, err error)
+	// RunningProcesses returns the PIDs of the compute and graphics processes
+	// running on the GPU.
+	RunningProcesses() ([]int, error)
+	// ConfComputeEnabled returns whether confidential computing is enabled
+	// for the GPU. CC mode is set system wide, errNVMLNotSupported is
+	// returned for GPUs without the capability.
+	ConfComputeEnabled() (bool, error)
+	// AccountingEnabled returns whether accounting mode is enabled.
+	AccountingEnabled() (bool, error)
+	// AccountingPids returns the running and exited processes kept in the
+	// accounting buffer.
+	AccountingPids() ([]int, error)
+	// AccountingStats returns the accounting data of the given process.
+	AccountingStats(pid int) (nvmlAccountingStats, error)
+}
+
+// attachNVML looks up the NVML handle of each NVIDIA GPU and fills in the
+// data that sysfs doesn't provide. NVML's product name takes precedence over
+// the built-in model names, which lag behind new products, and the sysfs
+// device ID they fall back to.
+func (c *gpuCollector) attachNVML(gpus []gpuDevice) {
+	for i := range gpus {
+		gpu := &gpus[i]
+		if gpu.vendorID != vendorNVIDIA {
+			continue
+		}
+
+		dev, err := c.nvml.DeviceByBusID(gpu.busID)
+		if err != nil {
+			c.logger.Debug("Failed to get NVML device", "busID", gpu.busID, "error", err)
+			continue
+		}
+		gpu.nvml = dev
+
+		if name, err := dev.Name(); err == nil && name != "" {
+			gpu.model = name
+		}
+		if gpu.memoryTotal == 0 {
+			if total, err := dev.MemoryTotal(); err == nil {
+				gpu.memoryTotal = total
+			}
+		}
+	}
+}
+
+func (c *gpuCollector) updateNVML(ch chan<- prometheus.Metric, gpus []gpuDevice) {
+	violationDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "violation_time_seconds_total"),
+		"Accumulated time the GPU was throttled by the given policy.",
+		[]string{"gpu_id", "policy"}, nil,
+	)
+	boardInfoDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "board_info"),
+		"Board information of the GPU from NVML, value is always 1.",
+		[]string{"gpu_id", "board_part_number"}, nil,
+	)
+	confComputeDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "confidential_compute_enabled"),
+		"Whether confidential computing mode is enabled for the GPU (0/1).",
+		[]string{"gpu_id"}, nil,
+	)
+	rowRemapPendingDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "row_remap_pending"),
+		"Whether a memory row remapping of the GPU is pending and needs a GPU reset to be applied (0/1).",
+		[]string{"gpu_id"}, nil,
+	)
+	rowRemapFailureDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "row_remap_failure"),
+		"Whether a memory row remapping of the GPU failed, the GPU should be replaced (0/1).",
+		[]string{"gpu_id"}, nil,
+	)
+	processesDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "processes"),
+		"Number of compute and graphics processes running on the GPU.",
+		[]string{"gpu_id"}, nil,
+	)
+	nvlinkUpDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "nvlink_up"),
+		"Whether the NVLink is active (0/1).",
+		[]string{"gpu_id", "link"}, nil,
+	)
+	nvlinkBandwidthDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "nvlink_bandwidth_bytes_total"),
+		"Bytes transferred over the NVLink.",
+		[]string{"gpu_id", "link", "direction"}, nil,
+	)
+
+	for _, gpu := range gpus {
+		if gpu.nvml == nil {
+			continue
+		}
+
+		partNumber, err := gpu.nvml.BoardPartNumber()
+		if err != nil {
+			if !errors.Is(err, errNVMLNotSupported) {
+				c.logger.Debug("Failed to get board part number", "busID", gpu.busID, "error", err)
+			}
+			partNumber = "unknown"
+		}
+		ch <- prometheus.MustNewConstMetric(boardInfoDesc, prometheus.GaugeValue, 1, gpu.gpuID(), partNumber)
+
+		ccEnabled, err := gpu.nvml.ConfComputeEnabled()
+		if err == nil {
+			value := 0.0
+			if ccEnabled {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(confComputeDesc, prometheus.GaugeValue, value, gpu.gpuID())
+		} else if !errors.Is(err, errNVMLNotSupported) {
+			c.logger.Debug("Failed to get confidential computing state", "busID", gpu.busID, "error", err)
+		}
+
+		pending, failure, err := gpu.nvml.RemappedRows()
+		if err == nil {
+			pendingValue, failureValue := 0.0, 0.0
+			if pending {
+				pendingValue = 1
+			}
+			if failure {
+				failureValue = 1
+			}
+			ch <- prometheus.MustNewConstMetric(rowRemapPendingDesc, prometheus.GaugeValue, pendingValue, gpu.gpuID())
+			ch <- prometheus.MustNewConstMetric(rowRemapFailureDesc, prometheus.GaugeValue, failureValue, gpu.gpuID())
+		} else if !errors.Is(err, errNVMLNotSupported) {
+			c.logger.Debug("Failed to get remapped rows", "busID", gpu.busID, "error", err)
+		}
+
+		if pids, err := gpu.nvml.RunningProcesses(); err == nil {
+			ch <- prometheus.MustNewConstMetric(processesDesc, prometheus.GaugeValue, float64(len(pids)), gpu.gpuID())
+		} else if !errors.Is(err, errNVMLNotSupported) {
+			c.logger.Debug("Failed to get running processes", "busID", gpu.busID, "error", err)
+		}
+
+		for _, p := range nvmlViolationPolicies {
+			ns, err := gpu.nvml.ViolationTime(p.policy)
+			if err != nil {
+				if !errors.Is(err, errNVMLNotSupported) {
+					c.logger.Debug("Failed to get violation status", "busID", gpu.busID, "policy", p.name, "error", err)
+				}
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(violationDesc, prometheus.CounterValue, float64(ns)/1e9, gpu.gpuID(), p.name)
+		}
+
+		for link := 0; link < nvmlNvLinkMaxLinks; link++ {
+			up, err := gpu.nvml.NvLinkState(link)
+			if err != nil {
+				if !errors.Is(err, errNVMLNotSupported) {
+					c.logger.Debug("Failed to get NVLink state", "busID", gpu.busID, "link", link, "error", err)
+				}
+				continue
+			}
+			linkLabel := strconv.Itoa(link)
+			value := 0.0
+			if up {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(nvlinkUpDesc, prometheus.GaugeValue, value, gpu.gpuID(), linkLabel)
+
+			// The utilization counters only count once configured through
+			// nvmlDeviceSetNvLinkUtilizationControl, and may count cycles
+			// or packets instead of bytes.
+			if unit, err := gpu.nvml.NvLinkUtilizationUnit(link); err != nil || unit != nvmlNvLinkCounterUnitBytes {
+				continue
+			}
+			rx, tx, err := gpu.nvml.NvLinkUtilization(link)
+			if err != nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(nvlinkBandwidthDesc, prometheus.CounterValue, float64(rx), gpu.gpuID(), linkLabel, "rx")
+			ch <- prometheus.MustNewConstMetric(nvlinkBandwidthDesc, prometheus.CounterValue, float64(tx), gpu.gpuID(), linkLabel, "tx")
+		}
+
+		if c.nvmlAccountingMaxProcesses > 0 {
+			c.updateNVMLAccounting(ch, gpu)
+		}
+	}
+}
+
+// updateNVMLAccounting exposes the accounting data NVML keeps for processes
+// that ran on the GPU, including exited ones. Nothing is exposed unless
+// accounting mode was enabled on the card, e.g. with nvidia-smi -am 1.
+func (c *gpuCollector) updateNVMLAccounting(ch chan<- prometheus.Metric, gpu gpuDevice) {
+	utilizationDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "accounting_gpu_utilization"),
+		"Average GPU utilization of the process over its lifetime from NVML accounting (0-1).",
+		[]string{"gpu_id", "pid"}, nil,
+	)
+	memoryDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "accounting_memory_bytes"),
+		"Maximum GPU memory used by the process from NVML accounting.",
+		[]string{"gpu_id", "pid"}, nil,
+	)
+
+	enabled, err := gpu.nvml.AccountingEnabled()
+	if err != nil {
+		if !errors.Is(err, errNVMLNotSupported) {
+			c.logger.Debug("Failed to get accounting mode", "busID", gpu.busID, "error", err)
+		}
+		return
+	}
+	if !enabled {
+		return
+	}
+
+	pids, err := gpu.nvml.AccountingPids()
+	if err != nil {
+		c.logger.Debug("Failed to get accounted processes", "busID", gpu.busID, "error", err)
+		return
+	}
+	if len(pids) > c.nvmlAccountingMaxProcesses {
+		c.logger.Debug("Too many accounted processes, truncating", "busID", gpu.busID, "processes", len(pids), "max", c.nvmlAccountingMaxProcesses)
+		pids = pids[:c.nvmlAccountingMaxProcesses]
+	}
+
+	for _, pid := range pids {
+		stats, err := gpu.nvml.AccountingStats(pid)
+		if err != nil {
+			// The process may have been evicted from the buffer meanwhile.
+			c.logger.Debug("Failed to get accounting stats", "busID", gpu.busID, "pid", pid, "error", err)
+			continue
+		}
+		pidLabel := strconv.Itoa(pid)
+		ch <- prometheus.MustNewConstMetric(utilizationDesc, prometheus.GaugeValue, float64(stats.gpuUtilization)/100, gpu.gpuID(), pidLabel)
+		ch <- prometheus.MustNewConstMetric(memoryDesc, prometheus.GaugeValue, float64(stats.maxMemoryUsage), gpu.gpuID(), pidLabel)
+	}
+}
@@ -0,0 +1,581 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeNVMLLibrary struct {
+	devices map[string]*fakeNVMLDevice
+}
+
+func (l fakeNVMLLibrary) DeviceByBusID(busID string) (nvmlDevice, error) {
+	dev, ok := l.devices[busID]
+	if !ok {
+		return nil, fmt.Errorf("no device at %s", busID)
+	}
+	return dev, nil
+}
+
+type fakeNVMLDevice struct {
+	name        string
+	memoryTotal uint64
+	violations  map[nvmlPerfPolicy]uint64
+	// temperature and powerUsage are 0 if not supported.
+	temperature uint32
+	powerUsage  uint32
+	clocks      map[nvmlClockType]uint32
+	maxClocks   map[nvmlClockType]uint32
+	// utilization is nil if not supported.
+	utilization *uint32
+	// eccMode holds the current and pending ECC mode, nil without ECC.
+	eccMode    *[2]bool
+	partNumber string
+	uuid       string
+	serial     string
+	// minor is nil if the device node can't be determined.
+	minor *int
+	// remappedRows holds the pending and failure flags, nil on GPUs
+	// without row remapping.
+	remappedRows *[2]bool
+	// processes is nil on GPUs not reporting their processes.
+	processes []int
+	// confCompute is nil on GPUs without confidential computing.
+	confCompute *bool
+	nvlinks     []fakeNVLink
+	// accounting is nil if accounting mode is disabled.
+	accounting map[int]nvmlAccountingStats
+}
+
+type fakeNVLink struct {
+	up bool
+	// unit is what the utilization counter counts, nil if it was never
+	// configured.
+	unit   *nvmlNvLinkCounterUnit
+	rx, tx uint64
+}
+
+func (d *fakeNVMLDevice) MemoryTotal() (uint64, error) {
+	if d.memoryTotal == 0 {
+		return 0, errNVMLNotSupported
+	}
+	return d.memoryTotal, nil
+}
+
+func (d *fakeNVMLDevice) ViolationTime(policy nvmlPerfPolicy) (uint64, error) {
+	ns, ok := d.violations[policy]
+	if !ok {
+		return 0, errNVMLNotSupported
+	}
+	return ns, nil
+}
+
+func (d *fakeNVMLDevice) Temperature() (uint32, error) {
+	if d.temperature == 0 {
+		return 0, errNVMLNotSupported
+	}
+	return d.temperature, nil
+}
+
+func (d *fakeNVMLDevice) PowerUsage() (uint32, error) {
+	if d.powerUsage == 0 {
+		return 0, errNVMLNotSupported
+	}
+	return d.powerUsage, nil
+}
+
+func (d *fakeNVMLDevice) ClockInfo(clock nvmlClockType) (uint32, error) {
+	mhz, ok := d.clocks[clock]
+	if !ok {
+		return 0, errNVMLNotSupported
+	}
+	return mhz, nil
+}
+
+func (d *fakeNVMLDevice) MaxClockInfo(clock nvmlClockType) (uint32, error) {
+	mhz, ok := d.maxClocks[clock]
+	if !ok {
+		return 0, errNVMLNotSupported
+	}
+	return mhz, nil
+}
+
+func (d *fakeNVMLDevice) Utilization() (uint32, error) {
+	if d.utilization == nil {
+		return 0, errNVMLNotSupported
+	}
+	return *d.utilization, nil
+}
+
+func (d *fakeNVMLDevice) EccMode() (bool, bool, error) {
+	if d.eccMode == nil {
+		return false, false, errNVMLNotSupported
+	}
+	return d.eccMode[0], d.eccMode[1], nil
+}
+
+func (d *fakeNVMLDevice) Name() (string, error) {
+	if d.name == "" {
+		return "", errNVMLNotSupported
+	}
+	return d.name, nil
+}
+
+func (d *fakeNVMLDevice) BoardPartNumber() (string, error) {
+	if d.partNumber == "" {
+		return "", errNVMLNotSupported
+	}
+	return d.partNumber, nil
+}
+
+func (d *fakeNVMLDevice) UUID() (string, error) {
+	if d.uuid == "" {
+		return "", errNVMLNotSupported
+	}
+	return d.uuid, nil
+}
+
+func (d *fakeNVMLDevice) RemappedRows() (bool, bool, error) {
+	if d.remappedRows == nil {
+		return false, false, errNVMLNotSupported
+	}
+	return d.remappedRows[0], d.remappedRows[1], nil
+}
+
+func (d *fakeNVMLDevice) Serial() (string, error) {
+	if d.serial == "" {
+		return "", errNVMLNotSupported
+	}
+	return d.serial, nil
+}
+
+func (d *fakeNVMLDevice) MinorNumber() (int, error) {
+	if d.minor == nil {
+		return 0, errNVMLNotSupported
+	}
+	return *d.minor, nil
+}
+
+func (d *fakeNVMLDevice) RunningProcesses() ([]int, error) {
+	if d.processes == nil {
+		return nil, errNVMLNotSupported
+	}
+	return d.processes, nil
+}
+
+func (d *fakeNVMLDevice) ConfComputeEnabled() (bool, error) {
+	if d.confCompute == nil {
+		return false, errNVMLNotSupported
+	}
+	return *d.confCompute, nil
+}
+
+func (d *fakeNVMLDevice) NvLinkState(link int) (bool, error) {
+	if link >= len(d.nvlinks) {
+		return false, errNVMLNotSupported
+	}
+	return d.nvlinks[link].up, nil
+}
+
+func (d *fakeNVMLDevice) NvLinkUtilizationUnit(link int) (nvmlNvLinkCounterUnit, error) {
+	if link >= len(d.nvlinks) || d.nvlinks[link].unit == nil {
+		return 0, errNVMLNotSupported
+	}
+	return *d.nvlinks[link].unit, nil
+}
+
+func (d *fakeNVMLDevice) NvLinkUtilization(link int) (uint64, uint64, error) {
+	if link >= len(d.nvlinks) {
+		return 0, 0, errNVMLNotSupported
+	}
+	return d.nvlinks[link].rx, d.nvlinks[link].tx, nil
+}
+
+func (d *fakeNVMLDevice) AccountingEnabled() (bool, error) {
+	return d.accounting != nil, nil
+}
+
+func (d *fakeNVMLDevice) AccountingPids() ([]int, error) {
+	pids := slices.Sorted(maps.Keys(d.accounting))
+	return pids, nil
+}
+
+func (d *fakeNVMLDevice) AccountingStats(pid int) (nvmlAccountingStats, error) {
+	stats, ok := d.accounting[pid]
+	if !ok {
+		return nvmlAccountingStats{}, fmt.Errorf("no process %d", pid)
+	}
+	return stats, nil
+}
+
+// testNVMLCollector runs the NVML part of the GPU collector against a fixed
+// set of GPUs.
+type testNVMLCollector struct {
+	c    *gpuCollector
+	gpus []gpuDevice
+}
+
+func (tc testNVMLCollector) Collect(ch chan<- prometheus.Metric) {
+	gpus := append([]gpuDevice(nil), tc.gpus...)
+	tc.c.attachNVML(gpus)
+	tc.c.updateNVML(ch, gpus)
+}
+
+func (tc testNVMLCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(tc, ch)
+}
+
+func TestGPUNVMLViolationTime(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {
+				violations: map[nvmlPerfPolicy]uint64{
+					nvmlPerfPolicyPower:   1500000000,
+					nvmlPerfPolicyThermal: 250000000,
+				},
+			},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		// Not an NVIDIA card, must not be queried.
+		{busID: "0000:65:00.0", vendorID: vendorAMD},
+	}
+
+	expected := `# HELP node_gpu_violation_time_seconds_total Accumulated time the GPU was throttled by the given policy.
+# TYPE node_gpu_violation_time_seconds_total counter
+node_gpu_violation_time_seconds_total{gpu_id="0000:17:00.0",policy="power"} 1.5
+node_gpu_violation_time_seconds_total{gpu_id="0000:17:00.0",policy="thermal"} 0.25
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_violation_time_seconds_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUNVMLNvLink(t *testing.T) {
+	bytes, packets := nvmlNvLinkCounterUnitBytes, nvmlNvLinkCounterUnitPackets
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {
+				nvlinks: []fakeNVLink{
+					{up: true, unit: &bytes, rx: 4096, tx: 8192},
+					{up: false, unit: &bytes},
+					// Counting packets or never configured, no bandwidth.
+					{up: true, unit: &packets, rx: 12, tx: 34},
+					{up: true},
+				},
+			},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{{busID: "0000:17:00.0", vendorID: vendorNVIDIA}}
+
+	expected := `# HELP node_gpu_nvlink_bandwidth_bytes_total Bytes transferred over the NVLink.
+# TYPE node_gpu_nvlink_bandwidth_bytes_total counter
+node_gpu_nvlink_bandwidth_bytes_total{direction="rx",gpu_id="0000:17:00.0",link="0"} 4096
+node_gpu_nvlink_bandwidth_bytes_total{direction="rx",gpu_id="0000:17:00.0",link="1"} 0
+node_gpu_nvlink_bandwidth_bytes_total{direction="tx",gpu_id="0000:17:00.0",link="0"} 8192
+node_gpu_nvlink_bandwidth_bytes_total{direction="tx",gpu_id="0000:17:00.0",link="1"} 0
+# HELP node_gpu_nvlink_up Whether the NVLink is active (0/1).
+# TYPE node_gpu_nvlink_up gauge
+node_gpu_nvlink_up{gpu_id="0000:17:00.0",link="0"} 1
+node_gpu_nvlink_up{gpu_id="0000:17:00.0",link="1"} 0
+node_gpu_nvlink_up{gpu_id="0000:17:00.0",link="2"} 1
+node_gpu_nvlink_up{gpu_id="0000:17:00.0",link="3"} 1
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_nvlink_up", "node_gpu_nvlink_bandwidth_bytes_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUNVMLBoardPartNumber(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {partNumber: "699-2G506-0200-000"},
+			"0000:65:00.0": {},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:65:00.0", vendorID: vendorNVIDIA},
+	}
+
+	expected := `# HELP node_gpu_board_info Board information of the GPU from NVML, value is always 1.
+# TYPE node_gpu_board_info gauge
+node_gpu_board_info{board_part_number="699-2G506-0200-000",gpu_id="0000:17:00.0"} 1
+node_gpu_board_info{board_part_number="unknown",gpu_id="0000:65:00.0"} 1
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_board_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUNVMLRemappedRows(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {remappedRows: &[2]bool{true, false}},
+			"0000:31:00.0": {remappedRows: &[2]bool{false, true}},
+			// Pre-Ampere GPU without row remapping.
+			"0000:65:00.0": {},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:31:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:65:00.0", vendorID: vendorNVIDIA},
+	}
+
+	expected := `# HELP node_gpu_row_remap_failure Whether a memory row remapping of the GPU failed, the GPU should be replaced (0/1).
+# TYPE node_gpu_row_remap_failure gauge
+node_gpu_row_remap_failure{gpu_id="0000:17:00.0"} 0
+node_gpu_row_remap_failure{gpu_id="0000:31:00.0"} 1
+# HELP node_gpu_row_remap_pending Whether a memory row remapping of the GPU is pending and needs a GPU reset to be applied (0/1).
+# TYPE node_gpu_row_remap_pending gauge
+node_gpu_row_remap_pending{gpu_id="0000:17:00.0"} 1
+node_gpu_row_remap_pending{gpu_id="0000:31:00.0"} 0
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_row_remap_pending", "node_gpu_row_remap_failure"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUNVMLAccounting(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {
+				accounting: map[int]nvmlAccountingStats{
+					1234: {gpuUtilization: 85, maxMemoryUsage: 4 << 30},
+					5678: {gpuUtilization: 10, maxMemoryUsage: 512 << 20},
+					9012: {gpuUtilization: 50, maxMemoryUsage: 1 << 30},
+				},
+			},
+			// Accounting mode disabled.
+			"0000:65:00.0": {},
+		},
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:65:00.0", vendorID: vendorNVIDIA},
+	}
+	metrics := []string{"node_gpu_accounting_gpu_utilization", "node_gpu_accounting_memory_bytes"}
+
+	for _, tc := range []struct {
+		name         string
+		maxProcesses int
+		expected     string
+	}{
+		{
+			name:         "disabled",
+			maxProcesses: 0,
+			expected:     "",
+		},
+		{
+			name:         "capped",
+			maxProcesses: 2,
+			expected: `# HELP node_gpu_accounting_gpu_utilization Average GPU utilization of the process over its lifetime from NVML accounting (0-1).
+# TYPE node_gpu_accounting_gpu_utilization gauge
+node_gpu_accounting_gpu_utilization{gpu_id="0000:17:00.0",pid="1234"} 0.85
+node_gpu_accounting_gpu_utilization{gpu_id="0000:17:00.0",pid="5678"} 0.1
+# HELP node_gpu_accounting_memory_bytes Maximum GPU memory used by the process from NVML accounting.
+# TYPE node_gpu_accounting_memory_bytes gauge
+node_gpu_accounting_memory_bytes{gpu_id="0000:17:00.0",pid="1234"} 4.294967296e+09
+node_gpu_accounting_memory_bytes{gpu_id="0000:17:00.0",pid="5678"} 5.36870912e+08
+`,
+		},
+	} {
+		c := &gpuCollector{
+			logger:                     slog.New(slog.NewTextHandler(io.Discard, nil)),
+			subsystem:                  "gpu",
+			nvml:                       lib,
+			nvmlAccountingMaxProcesses: tc.maxProcesses,
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(tc.expected), metrics...); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		}
+	}
+}
+
+func TestGPUNVMLConfCompute(t *testing.T) {
+	enabled, disabled := true, false
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {confCompute: &enabled},
+			"0000:18:00.0": {confCompute: &disabled},
+			// Not capable of confidential computing.
+			"0000:65:00.0": {},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:18:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:65:00.0", vendorID: vendorNVIDIA},
+	}
+
+	expected := `# HELP node_gpu_confidential_compute_enabled Whether confidential computing mode is enabled for the GPU (0/1).
+# TYPE node_gpu_confidential_compute_enabled gauge
+node_gpu_confidential_compute_enabled{gpu_id="0000:17:00.0"} 1
+node_gpu_confidential_compute_enabled{gpu_id="0000:18:00.0"} 0
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_confidential_compute_enabled"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorNVMLModelName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0000:17:00.0", "0000:18:00.0"} {
+		writeTestGPUDevice(t, dir, name, "nvidia", map[string]string{"class": "0x030200", "vendor": vendorNVIDIA, "device": "0x2330"})
+	}
+
+	*gpuSysfsPath = dir
+	defer func() { *gpuSysfsPath = "bus/pci/devices" }()
+	c := newTestGPUCollector(t)
+	c.nvml = fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {name: "NVIDIA H100 80GB HBM3"},
+			// The name can't be read, the built-in name is kept.
+			"0000:18:00.0": {},
+		},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_cards_total Total number of GPU cards detected.
+# TYPE node_gpu_cards_total gauge
+node_gpu_cards_total{model="NVIDIA H100 80GB HBM3"} 1
+node_gpu_cards_total{model="NVIDIA H100-PCIE"} 1
+# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{class_name="0x030200",device_id="0x2330",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="NVIDIA H100 80GB HBM3",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+node_gpu_info{class_name="0x030200",device_id="0x2330",gpu_id="0000:18:00.0",iommu_group="-1",minor="",model="NVIDIA H100-PCIE",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_cards_total", "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUCollectorDCGMLabels(t *testing.T) {
+	dir := t.TempDir()
+	writeTestGPUDevice(t, dir, "0000:17:00.0", "nvidia", map[string]string{"class": "0x030200", "vendor": vendorNVIDIA, "device": "0x2330"})
+	writeTestGPUDevice(t, dir, "0000:83:00.0", "amdgpu", map[string]string{"class": "0x038000", "vendor": vendorAMD, "device": "0x740c", "unique_id": "8f2c3a1d5e7b9046"})
+
+	*gpuSysfsPath = dir
+	*gpuDCGMLabels = true
+	defer func() {
+		*gpuSysfsPath = "bus/pci/devices"
+		*gpuDCGMLabels = false
+	}()
+	c := newTestGPUCollector(t)
+	minor := 3
+	c.nvml = fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {
+				name:  "NVIDIA H100 80GB HBM3",
+				uuid:  "GPU-5a1e6b8c-2f3d-4e9a-8b7c-0d1e2f3a4b5c",
+				minor: &minor,
+			},
+		},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_info Information about the GPU.
+# TYPE node_gpu_info gauge
+node_gpu_info{GPU_I_ID="",UUID="8f2c3a1d5e7b9046",class_name="0x038000",device="",device_id="0x740c",gpu_id="0000:83:00.0",iommu_group="-1",minor="",model="AMD Instinct MI250X/MI250",modelName="AMD Instinct MI250X/MI250",subsystem_device_id="",subsystem_vendor_id="",unique_id="8f2c3a1d5e7b9046",vendor="AMD/ATI",vendor_id="0x1002"} 1
+node_gpu_info{GPU_I_ID="",UUID="GPU-5a1e6b8c-2f3d-4e9a-8b7c-0d1e2f3a4b5c",class_name="0x030200",device="nvidia3",device_id="0x2330",gpu_id="0000:17:00.0",iommu_group="-1",minor="",model="NVIDIA H100 80GB HBM3",modelName="NVIDIA H100 80GB HBM3",subsystem_device_id="",subsystem_vendor_id="",unique_id="",vendor="NVIDIA Corporation",vendor_id="0x10de"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGPUNVMLProcesses(t *testing.T) {
+	lib := fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			"0000:17:00.0": {processes: []int{4242, 4243, 5100}},
+			"0000:31:00.0": {processes: []int{}},
+			// GPU not reporting its processes.
+			"0000:65:00.0": {},
+		},
+	}
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		nvml:      lib,
+	}
+	gpus := []gpuDevice{
+		{busID: "0000:17:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:31:00.0", vendorID: vendorNVIDIA},
+		{busID: "0000:65:00.0", vendorID: vendorNVIDIA},
+	}
+
+	expected := `# HELP node_gpu_processes Number of compute and graphics processes running on the GPU.
+# TYPE node_gpu_processes gauge
+node_gpu_processes{gpu_id="0000:17:00.0"} 3
+node_gpu_processes{gpu_id="0000:31:00.0"} 0
+`
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testNVMLCollector{c: c, gpus: gpus})
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_processes"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The sources of the GPU temperature, power and clock metrics.
+const (
+	gpuSourceAuto       = "auto"
+	gpuSourceGPUMetrics = "gpu_metrics"
+	gpuSourceHwmon      = "hwmon"
+	gpuSourceNVML       = "nvml"
+)
+
+var gpuAmbientSensor = kingpin.Flag("collector.gpu.ambient-sensor", "Path of a hwmon temp*_input file of an inlet or ambient temperature sensor, relative to --path.sysfs unless absolute, e.g. class/hwmon/hwmon3/temp1_input. If set, node_gpu_temperature_delta_celsius reports how much warmer each GPU runs than the air it takes in, a growing delta hints at clogged or failing cooling.").Default("").String()
+
+var gpuSource = kingpin.Flag("collector.gpu.source", "Source of the GPU temperature, power and clock metrics: auto, gpu_metrics, hwmon or nvml. auto takes each metric from the first source reporting it for the GPU, in order gpu_metrics, hwmon, nvml.").Default(gpuSourceAuto).Enum(gpuSourceAuto, gpuSourceGPUMetrics, gpuSourceHwmon, gpuSourceNVML)
+
+// gpuAutoSources is the order in which auto tries the sources, cheapest
+// first: amdgpu gpu_metrics has every value in a single read, hwmon needs a
+// read per value and NVML a driver call per value.
+var gpuAutoSources = []string{gpuSourceGPUMetrics, gpuSourceHwmon, gpuSourceNVML}
+
+// The clock domains of the clock label, the same whatever the source so
+// dashboards work across vendors:
+//
+//	domain    gpu_metrics  hwmon  NVML
+//	graphics  gfx          sclk   graphics
+//	memory    mem          mclk   mem
+//	soc       soc          -      -
+//	sm        -            -      sm
+//
+// gpu_metrics reports average clocks, exposed as node_gpu_average_clock_hertz,
+// hwmon and NVML the current clocks, exposed as node_gpu_clock_hertz.
+const (
+	gpuClockGraphics = "graphics"
+	gpuClockMemory   = "memory"
+	gpuClockSoC      = "soc"
+	gpuClockSM       = "sm"
+)
+
+// gpuHwmonClocks maps the amdgpu hwmon freq*_label to the clock domain.
+var gpuHwmonClocks = map[string]string{
+	"sclk": gpuClockGraphics,
+	"mclk": gpuClockMemory,
+}
+
+// gpuSensorReadings holds the temperature, power and clock readings of a GPU
+// from a single source.
+type gpuSensorReadings struct {
+	// temperatures in Celsius by sensor.
+	temperatures map[string]float64
+	// power in watts by rail.
+	power map[string]float64
+	// clocks in hertz by clock domain.
+	clocks map[string]float64
+	// averageClocks is set if the clocks are averages rather than current
+	// values.
+	averageClocks bool
+	// throttleStatus is the independent throttle status from amdgpu
+	// gpu_metrics, read regardless of the selected source.
+	throttleStatus *uint64
+}
+
+// merge fills the metrics r has no readings for from other. Each metric
+// of a GPU thus comes from a single source, avoiding duplicate series.
+func (r *gpuSensorReadings) merge(other gpuSensorReadings) {
+	if len(r.temperatures) == 0 {
+		r.temperatures = other.temperatures
+	}
+	if len(r.power) == 0 {
+		r.power = other.power
+	}
+	if len(r.clocks) == 0 {
+		r.clocks, r.averageClocks = other.clocks, other.averageClocks
+	}
+}
+
+func (r gpuSensorReadings) complete() bool {
+	return len(r.temperatures) > 0 && len(r.power) > 0 && len(r.clocks) > 0
+}
+
+// hottest returns the highest temperature of the readings.
+func (r gpuSensorReadings) hottest() (float64, bool) {
+	if len(r.temperatures) == 0 {
+		return 0, false
+	}
+	return slices.Max(slices.Collect(maps.Values(r.temperatures))), true
+}
+
+// memoryClock returns the memory clock of the readings.
+func (r gpuSensorReadings) memoryClock() (float64, bool) {
+	hertz, ok := r.clocks[gpuClockMemory]
+	return hertz, ok
+}
+
+// gpuDeltaSensors are the sensors compared against the ambient temperature,
+// in order of preference: the amdgpu edge sensor, the NVML core temperature
+// and the gfx sensor of gpu_metrics v2.
+var gpuDeltaSensors = []string{"edge", "gpu", "gfx"}
+
+// deltaTemperature returns the temperature of the first of gpuDeltaSensors
+// the readings have.
+func (r gpuSensorReadings) deltaTemperature() (float64, bool) {
+	for _, sensor := range gpuDeltaSensors {
+		if celsius, ok := r.temperatures[sensor]; ok {
+			return celsius, true
+		}
+	}
+	return 0, false
+}
+
+// readGPUHwmonSensors returns the hwmon temperatures, power rails and clocks
+// of the GPU at devicePath. Temperatures are keyed by their label, amdgpu
+// names them e.g. edge, junction and mem (HBM), falling back to the name of
+// the channel, e.g. temp2. Clocks are keyed by the domain of their label in
+// gpuHwmonClocks, others are skipped.
+func readGPUHwmonSensors(devicePath string) gpuSensorReadings {
+	r := gpuSensorReadings{
+		temperatures: make(map[string]float64),
+		power:        readGPUPowerRails(devicePath),
+		clocks:       make(map[string]float64),
+	}
+	files, err := filepath.Glob(filepath.Join(devicePath, "hwmon", "hwmon*", "*_input"))
+	if err != nil {
+		return r
+	}
+	for _, file := range files {
+		channel := strings.TrimSuffix(filepath.Base(file), "_input")
+		var values map[string]float64
+		var scale float64
+		switch {
+		case strings.HasPrefix(channel, "temp"):
+			values, scale = r.temperatures, 1e-3
+		case strings.HasPrefix(channel, "freq"):
+			values, scale = r.clocks, 1
+		default:
+			continue
+		}
+		value, err := readUintFromFile(file)
+		if err != nil {
+			continue
+		}
+		name := channel
+		if label, err := readSysfsFile(filepath.Join(filepath.Dir(file), channel+"_label")); err == nil && label != "" {
+			name = label
+		}
+		if strings.HasPrefix(channel, "freq") {
+			domain, ok := gpuHwmonClocks[name]
+			if !ok {
+				continue
+			}
+			name = domain
+		}
+		values[name] = float64(value) * scale
+	}
+	return r
+}
+
+// readNVMLSensors returns the core temperature, board power and current
+// clocks NVML reports for dev.
+func readNVMLSensors(dev nvmlDevice) gpuSensorReadings {
+	r := gpuSensorReadings{
+		temperatures: make(map[string]float64),
+		power:        make(map[string]float64),
+		clocks:       make(map[string]float64),
+	}
+	if celsius, err := dev.Temperature(); err == nil {
+		r.temperatures["gpu"] = float64(celsius)
+	}
+	if mw, err := dev.PowerUsage(); err == nil {
+		r.power["board"] = float64(mw) / 1e3
+	}
+	for _, clock := range nvmlClocks {
+		if mhz, err := dev.ClockInfo(clock.clock); err == nil {
+			r.clocks[clock.name] = float64(mhz) * 1e6
+		}
+	}
+	return r
+}
+
+// readSensors returns the temperatures, power and clocks of the GPU from the
+// sources selected by --collector.gpu.source, metrics being its gpu_metrics
+// if hasMetrics is set.
+func (c *gpuCollector) readSensors(gpu gpuDevice, metrics gpuMetrics, hasMetrics bool) gpuSensorReadings {
+	sources := gpuAutoSources
+	if c.source != gpuSourceAuto {
+		sources = []string{c.source}
+	}
+
+	var readings gpuSensorReadings
+	for _, source := range sources {
+		if readings.complete() {
+			break
+		}
+		switch source {
+		case gpuSourceGPUMetrics:
+			if hasMetrics {
+				readings.merge(metrics.sensorReadings())
+			}
+		case gpuSourceHwmon:
+			readings.merge(readGPUHwmonSensors(gpu.path))
+		case gpuSourceNVML:
+			if gpu.nvml != nil {
+				readings.merge(readNVMLSensors(gpu.nvml))
+			}
+		}
+	}
+	return readings
+}
+
+// readAllSensors reads the temperatures, power and clocks of each GPU from
+// the sources selected by --collector.gpu.source, along with the throttling
+// reasons amdgpu reports in gpu_metrics. It returns the readings keyed by bus
+// ID, so that a scrape reads the sensors once.
+func (c *gpuCollector) readAllSensors(gpus []gpuDevice) map[string]gpuSensorReadings {
+	allReadings := make(map[string]gpuSensorReadings, len(gpus))
+	for _, gpu := range gpus {
+		metrics, hasMetrics := c.readGPUMetrics(gpu)
+		readings := c.readSensors(gpu, metrics, hasMetrics)
+		if hasMetrics {
+			readings.throttleStatus = metrics.throttleStatus
+		}
+		allReadings[gpu.busID] = readings
+	}
+	return allReadings
+}
+
+// updateSensors exposes the temperatures, power and clocks of each GPU from
+// allReadings, along with the throttling reasons amdgpu reports in
+// gpu_metrics and whether thermal throttling flaps.
+func (c *gpuCollector) updateSensors(ch chan<- prometheus.Metric, gpus []gpuDevice, allReadings map[string]gpuSensorReadings) {
+	temperatureDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "temperature_celsius"),
+		"Temperature of the GPU per sensor.",
+		[]string{"gpu_id", "sensor"}, nil,
+	)
+	powerDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "power_watts"),
+		"Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.",
+		[]string{"gpu_id", "rail"}, nil,
+	)
+	averageClockDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "average_clock_hertz"),
+		"Average clock frequency of the GPU per clock domain from amdgpu gpu_metrics.",
+		[]string{"gpu_id", "clock"}, nil,
+	)
+	clockDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "clock_hertz"),
+		"Current clock frequency of the GPU per clock domain from hwmon or NVML.",
+		[]string{"gpu_id", "clock"}, nil,
+	)
+	throttleDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "throttle_status"),
+		"Whether the GPU is throttled for the given reason according to amdgpu gpu_metrics (0/1).",
+		[]string{"gpu_id", "reason"}, nil,
+	)
+	thermalFlappingDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "thermal_flapping"),
+		"Whether the GPU went in and out of thermal throttling at least --collector.gpu.thermal-flapping.threshold times over the last --collector.gpu.thermal-flapping.window scrapes, a sign of failing cooling (0/1).",
+		[]string{"gpu_id"}, nil,
+	)
+	temperatureDeltaDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "temperature_delta_celsius"),
+		"Temperature of the GPU's edge or core sensor minus the ambient temperature read from --collector.gpu.ambient-sensor.",
+		[]string{"gpu_id"}, nil,
+	)
+
+	var (
+		ambient    float64
+		hasAmbient bool
+	)
+	if c.ambientSensor != "" {
+		if millidegrees, err := readAmbientTemperature(c.ambientSensor); err == nil {
+			ambient, hasAmbient = float64(millidegrees)/1e3, true
+		} else {
+			c.logger.Debug("Failed to read ambient temperature", "path", c.ambientSensor, "error", err)
+		}
+	}
+
+	throttleSeen := make(map[string]bool)
+	for _, gpu := range gpus {
+		readings := allReadings[gpu.busID]
+		if readings.throttleStatus != nil {
+			for _, r := range gpuThrottleReasons {
+				value := 0.0
+				if *readings.throttleStatus&r.mask != 0 {
+					value = 1
+				}
+				ch <- prometheus.MustNewConstMetric(throttleDesc, prometheus.GaugeValue, value, gpu.gpuID(), r.reason)
+			}
+			if c.thermalHistory != nil {
+				throttled := *readings.throttleStatus&gpuThermalThrottleMask != 0
+				flapping := 0.0
+				if c.thermalHistory.observe(gpu.busID, throttled) {
+					flapping = 1
+				}
+				ch <- prometheus.MustNewConstMetric(thermalFlappingDesc, prometheus.GaugeValue, flapping, gpu.gpuID())
+				throttleSeen[gpu.busID] = true
+			}
+		}
+
+		for sensor, celsius := range readings.temperatures {
+			ch <- prometheus.MustNewConstMetric(temperatureDesc, prometheus.GaugeValue, celsius, gpu.gpuID(), sensor)
+		}
+		if hasAmbient {
+			if celsius, ok := readings.deltaTemperature(); ok {
+				ch <- prometheus.MustNewConstMetric(temperatureDeltaDesc, prometheus.GaugeValue, celsius-ambient, gpu.gpuID())
+			}
+		}
+		for rail, watts := range readings.power {
+			ch <- prometheus.MustNewConstMetric(powerDesc, prometheus.GaugeValue, watts, gpu.gpuID(), rail)
+		}
+		desc := clockDesc
+		if readings.averageClocks {
+			desc = averageClockDesc
+		}
+		for clock, hertz := range readings.clocks {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, hertz, gpu.gpuID(), clock)
+		}
+	}
+
+	if c.thermalHistory != nil {
+		c.thermalHistory.retain(throttleSeen)
+	}
+}
+
+// readAmbientTemperature reads the hwmon temp*_input file at path in
+// millidegrees Celsius. It's signed, inlet sensors of outdoor or chilled
+// systems can read below zero.
+func readAmbientTemperature(path string) (int64, error) {
+	value, err := readSysfsFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
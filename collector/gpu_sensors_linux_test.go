@@ -0,0 +1,253 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testSensorsCollector runs the temperature, power and clock part of the GPU
+// collector against a fixed set of GPUs.
+type testSensorsCollector struct {
+	c    *gpuCollector
+	gpus []gpuDevice
+}
+
+func (tc testSensorsCollector) Collect(ch chan<- prometheus.Metric) {
+	gpus := append([]gpuDevice(nil), tc.gpus...)
+	tc.c.attachNVML(gpus)
+	tc.c.updateSensors(ch, gpus, tc.c.readAllSensors(gpus))
+}
+
+func (tc testSensorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(tc, ch)
+}
+
+// newTestSensorGPUs returns an amdgpu card reporting its sensors through
+// both gpu_metrics and hwmon, and an NVIDIA card with NVML only.
+func newTestSensorGPUs(t *testing.T) []gpuDevice {
+	t.Helper()
+	amd := writeTestGPUDevice(t, t.TempDir(), "0000:03:00.0", "", map[string]string{
+		"gpu_metrics":                 string(newGPUMetricsV13(0)),
+		"hwmon/hwmon2/temp1_input":    "46000",
+		"hwmon/hwmon2/temp1_label":    "edge",
+		"hwmon/hwmon2/temp2_input":    "61000",
+		"hwmon/hwmon2/temp2_label":    "junction",
+		"hwmon/hwmon2/power1_average": "219000000",
+		"hwmon/hwmon2/freq1_input":    "1699000000",
+		"hwmon/hwmon2/freq1_label":    "sclk",
+	})
+	return []gpuDevice{
+		{busID: "0000:03:00.0", path: amd, vendorID: vendorAMD},
+		{busID: "0000:17:00.0", path: filepath.Join(t.TempDir(), "0000:17:00.0"), vendorID: vendorNVIDIA},
+	}
+}
+
+func newTestSensorsRegistry(t *testing.T, source string) *prometheus.Registry {
+	t.Helper()
+	c := &gpuCollector{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem: "gpu",
+		source:    source,
+		nvml: fakeNVMLLibrary{
+			devices: map[string]*fakeNVMLDevice{
+				"0000:17:00.0": {
+					temperature: 70,
+					powerUsage:  301500,
+					clocks:      map[nvmlClockType]uint32{nvmlClockGraphics: 1980, nvmlClockMem: 2619},
+				},
+			},
+		},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testSensorsCollector{c: c, gpus: newTestSensorGPUs(t)})
+	return reg
+}
+
+func TestGPUCollectorSourceAutoNoDuplicates(t *testing.T) {
+	reg := newTestSensorsRegistry(t, gpuSourceAuto)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sensors := make(map[string]string)
+	for _, mf := range families {
+		if mf.GetName() != "node_gpu_temperature_celsius" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var gpuID, sensor string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "gpu_id":
+					gpuID = l.GetValue()
+				case "sensor":
+					sensor = l.GetValue()
+				}
+			}
+			key := gpuID + "/" + sensor
+			if _, ok := sensors[key]; ok {
+				t.Errorf("duplicate node_gpu_temperature_celsius series for %s", key)
+			}
+			sensors[key] = sensor
+		}
+	}
+	// gpu_metrics wins over hwmon for the amdgpu card, NVML is the only
+	// source for the NVIDIA one.
+	want := []string{"0000:03:00.0/edge", "0000:03:00.0/hotspot", "0000:17:00.0/gpu"}
+	if len(sensors) != len(want) {
+		t.Errorf("got temperature series %v, want %v", sensors, want)
+	}
+	for _, key := range want {
+		if _, ok := sensors[key]; !ok {
+			t.Errorf("missing temperature series %s", key)
+		}
+	}
+}
+
+func TestGPUCollectorSource(t *testing.T) {
+	for _, tc := range []struct {
+		source   string
+		expected string
+	}{
+		{
+			source: gpuSourceAuto,
+			// The amdgpu card's clocks are averages from gpu_metrics, the
+			// NVIDIA card's current clocks from NVML, in the same domains.
+			expected: `# HELP node_gpu_average_clock_hertz Average clock frequency of the GPU per clock domain from amdgpu gpu_metrics.
+# TYPE node_gpu_average_clock_hertz gauge
+node_gpu_average_clock_hertz{clock="graphics",gpu_id="0000:03:00.0"} 1.7e+09
+node_gpu_average_clock_hertz{clock="memory",gpu_id="0000:03:00.0"} 1.6e+09
+node_gpu_average_clock_hertz{clock="soc",gpu_id="0000:03:00.0"} 1e+09
+# HELP node_gpu_clock_hertz Current clock frequency of the GPU per clock domain from hwmon or NVML.
+# TYPE node_gpu_clock_hertz gauge
+node_gpu_clock_hertz{clock="graphics",gpu_id="0000:17:00.0"} 1.98e+09
+node_gpu_clock_hertz{clock="memory",gpu_id="0000:17:00.0"} 2.619e+09
+# HELP node_gpu_power_watts Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.
+# TYPE node_gpu_power_watts gauge
+node_gpu_power_watts{gpu_id="0000:03:00.0",rail="socket"} 220
+node_gpu_power_watts{gpu_id="0000:17:00.0",rail="board"} 301.5
+# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="edge"} 45
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="hotspot"} 60
+node_gpu_temperature_celsius{gpu_id="0000:17:00.0",sensor="gpu"} 70
+`,
+		},
+		{
+			source: gpuSourceHwmon,
+			expected: `# HELP node_gpu_clock_hertz Current clock frequency of the GPU per clock domain from hwmon or NVML.
+# TYPE node_gpu_clock_hertz gauge
+node_gpu_clock_hertz{clock="graphics",gpu_id="0000:03:00.0"} 1.699e+09
+# HELP node_gpu_power_watts Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.
+# TYPE node_gpu_power_watts gauge
+node_gpu_power_watts{gpu_id="0000:03:00.0",rail="1"} 219
+# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="edge"} 46
+node_gpu_temperature_celsius{gpu_id="0000:03:00.0",sensor="junction"} 61
+`,
+		},
+		{
+			source: gpuSourceNVML,
+			expected: `# HELP node_gpu_clock_hertz Current clock frequency of the GPU per clock domain from hwmon or NVML.
+# TYPE node_gpu_clock_hertz gauge
+node_gpu_clock_hertz{clock="graphics",gpu_id="0000:17:00.0"} 1.98e+09
+node_gpu_clock_hertz{clock="memory",gpu_id="0000:17:00.0"} 2.619e+09
+# HELP node_gpu_power_watts Power drawn by the GPU per power rail: the hwmon rail index, socket from amdgpu gpu_metrics or board from NVML.
+# TYPE node_gpu_power_watts gauge
+node_gpu_power_watts{gpu_id="0000:17:00.0",rail="board"} 301.5
+# HELP node_gpu_temperature_celsius Temperature of the GPU per sensor.
+# TYPE node_gpu_temperature_celsius gauge
+node_gpu_temperature_celsius{gpu_id="0000:17:00.0",sensor="gpu"} 70
+`,
+		},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			reg := newTestSensorsRegistry(t, tc.source)
+			metrics := []string{"node_gpu_average_clock_hertz", "node_gpu_clock_hertz", "node_gpu_power_watts", "node_gpu_temperature_celsius"}
+			if err := testutil.GatherAndCompare(reg, strings.NewReader(tc.expected), metrics...); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGPUCollectorTemperatureDelta(t *testing.T) {
+	ambient := filepath.Join(t.TempDir(), "temp1_input")
+	if err := os.WriteFile(ambient, []byte("24500\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &gpuCollector{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		subsystem:     "gpu",
+		source:        gpuSourceHwmon,
+		ambientSensor: ambient,
+		nvml:          fakeNVMLLibrary{},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testSensorsCollector{c: c, gpus: newTestSensorGPUs(t)})
+
+	// The amdgpu edge sensor reads 46C, the NVIDIA card has no hwmon
+	// temperature and is left out.
+	expected := `# HELP node_gpu_temperature_delta_celsius Temperature of the GPU's edge or core sensor minus the ambient temperature read from --collector.gpu.ambient-sensor.
+# TYPE node_gpu_temperature_delta_celsius gauge
+node_gpu_temperature_delta_celsius{gpu_id="0000:03:00.0"} 21.5
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_temperature_delta_celsius"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sensors can read below zero.
+	if err := os.WriteFile(ambient, []byte("-5000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expected = `# HELP node_gpu_temperature_delta_celsius Temperature of the GPU's edge or core sensor minus the ambient temperature read from --collector.gpu.ambient-sensor.
+# TYPE node_gpu_temperature_delta_celsius gauge
+node_gpu_temperature_delta_celsius{gpu_id="0000:03:00.0"} 51
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_temperature_delta_celsius"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a readable ambient temperature there is no delta.
+	c.ambientSensor = filepath.Join(t.TempDir(), "missing")
+	if n, err := testutil.GatherAndCount(reg, "node_gpu_temperature_delta_celsius"); err != nil || n != 0 {
+		t.Errorf("got %d series (err %v), want none without ambient sensor", n, err)
+	}
+}
+
+func TestGPUCollectorAmbientSensorPath(t *testing.T) {
+	*gpuAmbientSensor = "class/hwmon/hwmon3/temp1_input"
+	defer func() { *gpuAmbientSensor = "" }()
+	if got, want := newTestGPUCollector(t).ambientSensor, "fixtures/sys/class/hwmon/hwmon3/temp1_input"; got != want {
+		t.Errorf("got ambient sensor %q, want %q under --path.sysfs", got, want)
+	}
+
+	*gpuAmbientSensor = "/run/inlet/temp1_input"
+	if got, want := newTestGPUCollector(t).ambientSensor, "/run/inlet/temp1_input"; got != want {
+		t.Errorf("got ambient sensor %q, want absolute path %q", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	gpuThermalFlappingWindow    = kingpin.Flag("collector.gpu.thermal-flapping.window", "Number of scrapes of the amdgpu thermal throttle status kept per GPU to detect flapping, 0 disables node_gpu_thermal_flapping.").Default("10").Int()
+	gpuThermalFlappingThreshold = kingpin.Flag("collector.gpu.thermal-flapping.threshold", "Number of thermal throttling transitions within --collector.gpu.thermal-flapping.window scrapes from which a GPU is reported as flapping.").Default("4").Int()
+	gpuMemClockThrottleRatio    = kingpin.Flag("collector.gpu.memory-clock-throttle.ratio", "Ratio of the current to the highest memory clock, the highest amdgpu pp_dpm_mclk level or the NVML maximum, below which a busy GPU is reported as throttled by node_gpu_memory_clock_throttled.").Default("0.5").Float64()
+	gpuMemClockThrottleMinUtil  = kingpin.Flag("collector.gpu.memory-clock-throttle.min-utilization", "Utilization ratio from which a GPU counts as busy for node_gpu_memory_clock_throttled, an idle GPU lowering its memory clock isn't throttled.").Default("0.8").Float64()
+)
+
+// gpuThrottleHistory keeps the last thermal throttle states of each GPU.
+type gpuThrottleHistory struct {
+	window    int
+	threshold int
+
+	mu     sync.Mutex
+	states map[string][]bool
+}
+
+func newGPUThrottleHistory(window, threshold int) *gpuThrottleHistory {
+	return &gpuThrottleHistory{
+		window:    window,
+		threshold: threshold,
+		states:    make(map[string][]bool),
+	}
+}
+
+// observe records the thermal throttle state of the GPU and returns whether
+// it flapped within the window.
+func (h *gpuThrottleHistory) observe(busID string, throttled bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	states := append(h.states[busID], throttled)
+	if len(states) > h.window {
+		states = states[len(states)-h.window:]
+	}
+	h.states[busID] = states
+
+	transitions := 0
+	for i := 1; i < len(states); i++ {
+		if states[i] != states[i-1] {
+			transitions++
+		}
+	}
+	return transitions >= h.threshold
+}
+
+// retain drops the history of the GPUs not in seen, e.g. removed cards.
+func (h *gpuThrottleHistory) retain(seen map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for busID := range h.states {
+		if !seen[busID] {
+			delete(h.states, busID)
+		}
+	}
+}
+
+// parseAMDGPUMaxDPMClock returns the highest clock level in hertz of an
+// amdgpu pp_dpm_* file, e.g. "0: 96Mhz\n1: 456Mhz *\n2: 1000Mhz".
+func parseAMDGPUMaxDPMClock(data string) (float64, error) {
+	var highest float64
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		_, level, ok := strings.Cut(line, ":")
+		if !ok {
+			return 0, fmt.Errorf("invalid clock level %q", line)
+		}
+		level = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(level), "*"))
+		mhz, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(level), "mhz"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid clock level %q: %w", line, err)
+		}
+		highest = max(highest, mhz*1e6)
+	}
+	if highest == 0 {
+		return 0, errors.New("no clock levels found")
+	}
+	return highest, nil
+}
+
+// updateMemoryClockThrottle exposes whether the memory clock of each GPU is
+// pinned low while the GPU is busy, a sign of memory overheating or a power
+// limit. readings are the sensor readings of the GPUs keyed by bus ID and
+// utilization their utilization ratio keyed by gpu_id label. GPUs with NVML
+// report their highest memory clock and utilization through it.
+func (c *gpuCollector) updateMemoryClockThrottle(ch chan<- prometheus.Metric, gpus []gpuDevice, readings map[string]gpuSensorReadings, utilization map[string]float64) {
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "memory_clock_throttled"),
+		"Whether the memory clock of the GPU is below --collector.gpu.memory-clock-throttle.ratio of its highest level, the highest amdgpu pp_dpm_mclk level or the NVML maximum memory clock, while its utilization is at least --collector.gpu.memory-clock-throttle.min-utilization (0/1), -1 if the clocks or the utilization are unknown.",
+		[]string{"gpu_id"}, nil,
+	)
+	for _, gpu := range gpus {
+		value := -1.0
+		current, hasCurrent := readings[gpu.busID].memoryClock()
+		highest, hasHighest := c.maxMemoryClock(gpu)
+		util, hasUtil := utilization[gpu.gpuID()]
+		if !hasUtil && gpu.nvml != nil {
+			if percent, err := gpu.nvml.Utilization(); err == nil {
+				util, hasUtil = float64(percent)/100, true
+			}
+		}
+		if hasCurrent && hasHighest && hasUtil {
+			value = 0
+			if util >= c.memClockThrottleMinUtil && current < highest*c.memClockThrottleRatio {
+				value = 1
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, gpu.gpuID())
+	}
+}
+
+// maxMemoryClock returns the highest memory clock of the GPU in hertz, from
+// NVML or the amdgpu pp_dpm_mclk levels.
+func (c *gpuCollector) maxMemoryClock(gpu gpuDevice) (float64, bool) {
+	if gpu.nvml != nil {
+		mhz, err := gpu.nvml.MaxClockInfo(nvmlClockMem)
+		if err != nil {
+			return 0, false
+		}
+		return float64(mhz) * 1e6, true
+	}
+	data, err := os.ReadFile(filepath.Join(gpu.path, "pp_dpm_mclk"))
+	if err != nil {
+		return 0, false
+	}
+	highest, err := parseAMDGPUMaxDPMClock(string(data))
+	if err != nil {
+		c.logger.Debug("Failed to parse pp_dpm_mclk", "busID", gpu.busID, "error", err)
+		return 0, false
+	}
+	return highest, true
+}
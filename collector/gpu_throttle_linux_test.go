@@ -0,0 +1,161 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGPUCollectorThermalFlapping(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestGPUDevice(t, dir, "0000:04:00.0", "amdgpu", map[string]string{"class": "0x030000", "vendor": vendorAMD, "device": "0x1681"})
+
+	*gpuSysfsPath = dir
+	*gpuThermalFlappingWindow = 4
+	*gpuThermalFlappingThreshold = 3
+	t.Cleanup(func() {
+		*gpuSysfsPath = "bus/pci/devices"
+		*gpuThermalFlappingWindow = 10
+		*gpuThermalFlappingThreshold = 4
+	})
+	reg := newTestGPURegistry(t)
+
+	for i, step := range []struct {
+		throttled bool
+		want      int
+	}{
+		{true, 0},
+		{false, 0},
+		{true, 0},
+		// Third transition within the last 4 scrapes.
+		{false, 1},
+		// The first transition leaves the window.
+		{false, 0},
+	} {
+		// Power throttling alone doesn't count.
+		status := uint64(1 << 0)
+		if step.throttled {
+			status |= 1 << 33
+		}
+		if err := os.WriteFile(filepath.Join(path, "gpu_metrics"), newGPUMetricsV22(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		expected := fmt.Sprintf(`# HELP node_gpu_thermal_flapping Whether the GPU went in and out of thermal throttling at least --collector.gpu.thermal-flapping.threshold times over the last --collector.gpu.thermal-flapping.window scrapes, a sign of failing cooling (0/1).
+# TYPE node_gpu_thermal_flapping gauge
+node_gpu_thermal_flapping{gpu_id="0000:04:00.0"} %d
+`, step.want)
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_thermal_flapping"); err != nil {
+			t.Fatalf("scrape %d: %v", i, err)
+		}
+	}
+}
+
+func TestParseAMDGPUMaxDPMClock(t *testing.T) {
+	got, err := parseAMDGPUMaxDPMClock("0: 96Mhz \n1: 456Mhz *\n2: 1000Mhz \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1e9 {
+		t.Errorf("got %v, want 1e9", got)
+	}
+
+	for _, data := range []string{"", "0: fast\n"} {
+		if _, err := parseAMDGPUMaxDPMClock(data); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestGPUCollectorMemoryClockThrottled(t *testing.T) {
+	dir := t.TempDir()
+	for name, files := range map[string]map[string]string{
+		// Busy with the memory clock pinned at its lowest level.
+		"0000:03:00.0": {"mclk": "96000000", "gpu_busy_percent": "97"},
+		// Busy at the highest memory clock.
+		"0000:23:00.0": {"mclk": "1000000000", "gpu_busy_percent": "97"},
+		// Idle, a low memory clock is expected.
+		"0000:43:00.0": {"mclk": "96000000", "gpu_busy_percent": "3"},
+		// No memory clock reading.
+		"0000:63:00.0": {"gpu_busy_percent": "97"},
+	} {
+		contents := map[string]string{
+			"class":            "0x038000",
+			"vendor":           vendorAMD,
+			"device":           "0x740c",
+			"gpu_busy_percent": files["gpu_busy_percent"],
+			"pp_dpm_mclk":      "0: 96Mhz \n1: 456Mhz \n2: 1000Mhz *",
+		}
+		if mclk, ok := files["mclk"]; ok {
+			contents[filepath.Join("hwmon", "hwmon0", "freq2_input")] = mclk
+			contents[filepath.Join("hwmon", "hwmon0", "freq2_label")] = "mclk"
+		}
+		writeTestGPUDevice(t, dir, name, "amdgpu", contents)
+	}
+
+	// NVIDIA GPUs report their clocks and utilization through NVML.
+	for _, name := range []string{"0000:17:00.0", "0000:18:00.0", "0000:19:00.0"} {
+		writeTestGPUDevice(t, dir, name, "nvidia", map[string]string{"class": "0x030200", "vendor": vendorNVIDIA, "device": "0x2330"})
+	}
+	busy, idle := uint32(95), uint32(0)
+
+	*gpuSysfsPath = dir
+	t.Cleanup(func() { *gpuSysfsPath = "bus/pci/devices" })
+	c := newTestGPUCollector(t)
+	c.nvml = fakeNVMLLibrary{
+		devices: map[string]*fakeNVMLDevice{
+			// Busy with the memory clock pinned low.
+			"0000:17:00.0": {
+				clocks:      map[nvmlClockType]uint32{nvmlClockMem: 405},
+				maxClocks:   map[nvmlClockType]uint32{nvmlClockMem: 2619},
+				utilization: &busy,
+			},
+			// Idle at a low memory clock.
+			"0000:18:00.0": {
+				clocks:      map[nvmlClockType]uint32{nvmlClockMem: 405},
+				maxClocks:   map[nvmlClockType]uint32{nvmlClockMem: 2619},
+				utilization: &idle,
+			},
+			// No maximum memory clock.
+			"0000:19:00.0": {
+				clocks:      map[nvmlClockType]uint32{nvmlClockMem: 405},
+				utilization: &busy,
+			},
+		},
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testGPUCollector{c: c})
+
+	expected := `# HELP node_gpu_memory_clock_throttled Whether the memory clock of the GPU is below --collector.gpu.memory-clock-throttle.ratio of its highest level, the highest amdgpu pp_dpm_mclk level or the NVML maximum memory clock, while its utilization is at least --collector.gpu.memory-clock-throttle.min-utilization (0/1), -1 if the clocks or the utilization are unknown.
+# TYPE node_gpu_memory_clock_throttled gauge
+node_gpu_memory_clock_throttled{gpu_id="0000:03:00.0"} 1
+node_gpu_memory_clock_throttled{gpu_id="0000:17:00.0"} 1
+node_gpu_memory_clock_throttled{gpu_id="0000:18:00.0"} 0
+node_gpu_memory_clock_throttled{gpu_id="0000:19:00.0"} -1
+node_gpu_memory_clock_throttled{gpu_id="0000:23:00.0"} 0
+node_gpu_memory_clock_throttled{gpu_id="0000:43:00.0"} 0
+node_gpu_memory_clock_throttled{gpu_id="0000:63:00.0"} -1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_memory_clock_throttled"); err != nil {
+		t.Fatal(err)
+	}
+}
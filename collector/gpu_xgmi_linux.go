@@ -0,0 +1,116 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rasErrorCount is the content of an amdgpu RAS *_err_count file.
+type rasErrorCount struct {
+	uncorrectable uint64
+	correctable   uint64
+}
+
+// parseRASErrorCount parses an amdgpu RAS error count file, which has the
+// format:
+//
+//	ue: 0
+//	ce: 12
+func parseRASErrorCount(data string) (rasErrorCount, error) {
+	var count rasErrorCount
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return count, fmt.Errorf("invalid RAS error count line %q", line)
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return count, fmt.Errorf("invalid RAS error count line %q: %w", line, err)
+		}
+		switch strings.TrimSpace(key) {
+		case "ue":
+			count.uncorrectable = n
+		case "ce":
+			count.correctable = n
+		}
+	}
+	return count, nil
+}
+
+// updateXGMI exposes the XGMI hive peers and RAS error counters of amdgpu
+// cards. Cards that aren't part of an XGMI hive are skipped.
+func (c *gpuCollector) updateXGMI(ch chan<- prometheus.Metric, gpus []gpuDevice) {
+	peerPresentDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "xgmi_peer_present"),
+		"Whether the peer GPU listed in the GPU's amdgpu xgmi_hive_info is present as a PCI device (0/1). This doesn't tell whether the XGMI link to it is trained.",
+		[]string{"gpu_id", "peer"}, nil,
+	)
+	errorsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, c.subsystem, "xgmi_errors_total"),
+		"Number of XGMI/WAFL errors of the GPU over all its links reported by amdgpu RAS.",
+		[]string{"gpu_id", "type"}, nil,
+	)
+
+	for _, gpu := range gpus {
+		if gpu.vendorID != vendorAMD {
+			continue
+		}
+
+		// xgmi_hive_info holds a nodeN link to every card of the hive,
+		// including the card itself.
+		hivePath := filepath.Join(gpu.path, "xgmi_hive_info")
+		nodes, err := filepath.Glob(filepath.Join(hivePath, "node*"))
+		if err != nil || len(nodes) == 0 {
+			continue
+		}
+		self, err := filepath.EvalSymlinks(gpu.path)
+		if err != nil {
+			continue
+		}
+		for _, node := range nodes {
+			peer, err := filepath.EvalSymlinks(node)
+			if err == nil && peer == self {
+				continue
+			}
+			present := 0.0
+			if err == nil {
+				if _, err := os.Stat(filepath.Join(peer, "vendor")); err == nil {
+					present = 1
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(peerPresentDesc, prometheus.GaugeValue, present, gpu.gpuID(), filepath.Base(node))
+		}
+
+		data, err := os.ReadFile(filepath.Join(gpu.path, "ras", "xgmi_wafl_err_count"))
+		if err != nil {
+			continue
+		}
+		count, err := parseRASErrorCount(string(data))
+		if err != nil {
+			c.logger.Debug("Failed to parse XGMI error count", "busID", gpu.busID, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(count.correctable), gpu.gpuID(), "correctable")
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(count.uncorrectable), gpu.gpuID(), "uncorrectable")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRASErrorCount(t *testing.T) {
+	count, err := parseRASErrorCount("ue: 3\nce: 12\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.uncorrectable != 3 || count.correctable != 12 {
+		t.Errorf("unexpected count %+v", count)
+	}
+
+	for _, data := range []string{"ue 3\n", "ue: three\n", "ce: -1\n"} {
+		if _, err := parseRASErrorCount(data); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+}
+
+func TestGPUCollectorXGMI(t *testing.T) {
+	reg := newTestGPURegistry(t)
+
+	// The two MI250X cards form a hive, the vfio-bound MI210 has no XGMI.
+	expected := `# HELP node_gpu_xgmi_errors_total Number of XGMI/WAFL errors of the GPU over all its links reported by amdgpu RAS.
+# TYPE node_gpu_xgmi_errors_total counter
+node_gpu_xgmi_errors_total{gpu_id="0000:83:00.0",type="correctable"} 5
+node_gpu_xgmi_errors_total{gpu_id="0000:83:00.0",type="uncorrectable"} 0
+node_gpu_xgmi_errors_total{gpu_id="0000:84:00.0",type="correctable"} 0
+node_gpu_xgmi_errors_total{gpu_id="0000:84:00.0",type="uncorrectable"} 0
+# HELP node_gpu_xgmi_peer_present Whether the peer GPU listed in the GPU's amdgpu xgmi_hive_info is present as a PCI device (0/1). This doesn't tell whether the XGMI link to it is trained.
+# TYPE node_gpu_xgmi_peer_present gauge
+node_gpu_xgmi_peer_present{gpu_id="0000:83:00.0",peer="node2"} 1
+node_gpu_xgmi_peer_present{gpu_id="0000:84:00.0",peer="node1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_gpu_xgmi_peer_present", "node_gpu_xgmi_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,25 +15,80 @@ package collector
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+	"weak"
 )
 
+// pciIdsPaths are the locations searched for pci.ids.
+var pciIdsPaths = []string{
+	"/usr/share/misc/pci.ids",
+	"/usr/share/hwdata/pci.ids",
+	"/var/lib/pciutils/pci.ids",
+}
+
+// embeddedPCIIDs holds a copy of pci.ids compiled into the binary. It is only
+// populated when building with the embedpciids tag.
+var embeddedPCIIDs []byte
+
 type pciIDProvider struct {
+	// mu guards the lookup maps against a concurrent Reload.
+	mu            sync.RWMutex
 	pciVendors    map[string]string
 	pciDevices    map[string]map[string]string
 	pciSubsystems map[string]map[string]string
 	pciClasses    map[string]string
 	pciSubclasses map[string]string
 	pciProgIfs    map[string]string
-	logger        *slog.Logger
+	useEmbedded   bool
+	paths         []string
+	customPath    string
+	// dir holds split *.ids files merged over the pci.ids file, empty if
+	// not set.
+	dir string
+	// source is the pci.ids file in use, "embedded" for the built-in copy
+	// or empty if nothing could be loaded.
+	source string
+	// modTime is the modification time of the loaded pci.ids file, zero for
+	// the embedded copy or if no file was loaded.
+	modTime time.Time
+	logger  *slog.Logger
+
+	// stopRefresh cancels the background refresh, nil if not running.
+	stopRefresh context.CancelFunc
+	refreshDone chan struct{}
 }
 
-func newPCIIDProvider(logger *slog.Logger, paths []string, customPath string) *pciIDProvider {
-	p := &pciIDProvider{
+// newPCIIDProvider loads the PCI ID database. A non-zero refreshInterval
+// reloads it periodically until stop is called.
+func newPCIIDProvider(logger *slog.Logger, paths []string, customPath, dir string, useEmbedded bool, refreshInterval time.Duration) *pciIDProvider {
+	p := newEmptyPCIIDProvider(logger, paths, customPath, dir, useEmbedded)
+	p.load(paths, customPath)
+
+	if refreshInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stopRefresh = cancel
+		p.refreshDone = make(chan struct{})
+		go refreshPCIIDs(ctx, weak.Make(p), refreshInterval, p.refreshDone)
+	}
+	return p
+}
+
+func newEmptyPCIIDProvider(logger *slog.Logger, paths []string, customPath, dir string, useEmbedded bool) *pciIDProvider {
+	return &pciIDProvider{
 		logger:        logger,
+		useEmbedded:   useEmbedded,
+		paths:         paths,
+		customPath:    customPath,
+		dir:           dir,
 		pciVendors:    make(map[string]string),
 		pciDevices:    make(map[string]map[string]string),
 		pciSubsystems: make(map[string]map[string]string),
@@ -41,56 +96,227 @@ func newPCIIDProvider(logger *slog.Logger, paths []string, customPath string) *p
 		pciSubclasses: make(map[string]string),
 		pciProgIfs:    make(map[string]string),
 	}
-	p.load(paths, customPath)
-	return p
 }
 
+// refreshPCIIDs reloads the provider every interval. It only holds a weak
+// reference so that providers of discarded collectors, e.g. those created
+// for filtered scrapes, can be garbage collected and end the loop.
+func refreshPCIIDs(ctx context.Context, wp weak.Pointer[pciIDProvider], interval time.Duration, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p := wp.Value()
+			if p == nil {
+				return
+			}
+			p.Reload()
+		}
+	}
+}
+
+// Reload re-reads the PCI ID database and atomically replaces the lookup
+// maps. Lookups keep using the previous data until the new one is parsed,
+// and after it if nothing could be loaded, e.g. while pci.ids is replaced.
+func (p *pciIDProvider) Reload() {
+	fresh := newEmptyPCIIDProvider(p.logger, p.paths, p.customPath, p.dir, p.useEmbedded)
+	fresh.load(p.paths, p.customPath)
+	if len(fresh.pciVendors) == 0 && len(fresh.pciClasses) == 0 {
+		p.logger.Warn("Reloading PCI IDs found no entries, keeping the previous ones", "source", p.getSource())
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pciVendors = fresh.pciVendors
+	p.pciDevices = fresh.pciDevices
+	p.pciSubsystems = fresh.pciSubsystems
+	p.pciClasses = fresh.pciClasses
+	p.pciSubclasses = fresh.pciSubclasses
+	p.pciProgIfs = fresh.pciProgIfs
+	p.source = fresh.source
+	p.modTime = fresh.modTime
+}
+
+// stop ends the background refresh and waits for it to exit.
+func (p *pciIDProvider) stop() {
+	if p.stopRefresh == nil {
+		return
+	}
+	p.stopRefresh()
+	<-p.refreshDone
+}
+
+// load reads the pci.ids file, or the embedded copy if enabled and there is
+// none, and then merges the files of the ids directory over it.
 func (p *pciIDProvider) load(paths []string, customPath string) {
-	var file *os.File
-	var err error
+	if p.dir != "" {
+		defer p.loadDir(p.dir)
+	}
+
+	file, err := p.open(paths, customPath)
+	if err != nil {
+		if p.useEmbedded {
+			p.loadEmbedded()
+		}
+		return
+	}
+	defer file.Close()
+
+	p.source = file.Name()
+	if fi, err := file.Stat(); err == nil {
+		p.modTime = fi.ModTime()
+	}
+	if err := p.parse(file); err != nil {
+		p.logger.Debug("Failed to parse PCI IDs file", "file", file.Name(), "error", err)
+	}
+}
+
+// loadDir merges the *.ids files in dir in lexical order, e.g. 10-base.ids
+// before 20-local.ids. Entries of later files override those of earlier
+// files and of the pci.ids file. The directory becomes the source if no
+// pci.ids file was loaded.
+func (p *pciIDProvider) loadDir(dir string) {
+	// Glob returns the matches sorted.
+	files, err := filepath.Glob(filepath.Join(dir, "*.ids"))
+	if err != nil || len(files) == 0 {
+		p.logger.Debug("No PCI IDs files found in directory", "dir", dir, "error", err)
+		return
+	}
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			p.logger.Debug("Failed to open PCI IDs file", "file", path, "error", err)
+			continue
+		}
+		p.logger.Debug("Loading PCI IDs from", "file", path)
+		if err := p.parse(file); err != nil {
+			p.logger.Debug("Failed to parse PCI IDs file", "file", path, "error", err)
+		}
+		file.Close()
+	}
+	if p.source == "" {
+		p.source = dir
+	}
+}
+
+// getAge returns the age of the loaded pci.ids file at now, judged by its
+// modification time. ok is false for the embedded copy or if no file was
+// loaded.
+func (p *pciIDProvider) getAge(now time.Time) (age time.Duration, ok bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.modTime.IsZero() {
+		return 0, false
+	}
+	return now.Sub(p.modTime), true
+}
 
+// getSource returns the pci.ids file in use.
+func (p *pciIDProvider) getSource() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.source
+}
+
+// open returns the custom pci.ids file if set, or the first default path
+// that can be opened.
+func (p *pciIDProvider) open(paths []string, customPath string) (*os.File, error) {
 	// Use custom pci.ids file if specified
 	if customPath != "" {
-		file, err = os.Open(customPath)
+		file, err := os.Open(customPath)
 		if err != nil {
 			p.logger.Debug("Failed to open PCI IDs file", "file", customPath, "error", err)
-			return
+			return nil, err
 		}
 		p.logger.Debug("Loading PCI IDs from", "file", customPath)
-	} else {
-		// Try each possible default path
-		for _, path := range paths {
-			fullPath := rootfsFilePath(path)
-			file, err = os.Open(fullPath)
-			if err == nil {
-				p.logger.Debug("Loading PCI IDs from default path", "path", fullPath)
-				break
-			}
-		}
-		if err != nil {
-			p.logger.Debug("Failed to open any default PCI IDs file", "error", err)
-			return
+		return file, nil
+	}
+
+	// Try each possible default path
+	err := os.ErrNotExist
+	for _, path := range paths {
+		fullPath := rootfsFilePath(path)
+		var file *os.File
+		file, err = os.Open(fullPath)
+		if err == nil {
+			p.logger.Debug("Loading PCI IDs from default path", "path", fullPath)
+			return file, nil
 		}
 	}
-	defer file.Close()
+	p.logger.Debug("Failed to open any default PCI IDs file", "error", err)
+	return nil, err
+}
 
-	scanner := bufio.NewScanner(file)
+// loadEmbedded parses the pci.ids copy compiled into the binary.
+func (p *pciIDProvider) loadEmbedded() {
+	if len(embeddedPCIIDs) == 0 {
+		p.logger.Warn("No embedded PCI IDs available, rebuild with the embedpciids tag")
+		return
+	}
+	p.logger.Debug("Loading embedded PCI IDs")
+	p.source = "embedded"
+	if err := p.parse(bytes.NewReader(embeddedPCIIDs)); err != nil {
+		p.logger.Debug("Failed to parse embedded PCI IDs", "error", err)
+	}
+}
+
+// Initial sizes of the lookup maps, roughly those of the upstream pci.ids.
+const (
+	pciIDsVendorsHint    = 2500
+	pciIDsSubsystemsHint = 8000
+)
+
+// splitPCIIDLine splits a pci.ids entry into its ID and its name, which are
+// separated by two spaces.
+func splitPCIIDLine(line []byte) (id, name []byte, ok bool) {
+	i := bytes.Index(line, []byte("  "))
+	if i < 0 {
+		return nil, nil, false
+	}
+	return bytes.TrimSpace(line[:i]), bytes.TrimSpace(line[i+2:]), true
+}
+
+// parse reads pci.ids formatted data and populates the lookup maps.
+func (p *pciIDProvider) parse(r io.Reader) error {
+	if len(p.pciVendors) == 0 {
+		p.pciVendors = make(map[string]string, pciIDsVendorsHint)
+	}
+	if len(p.pciDevices) == 0 {
+		p.pciDevices = make(map[string]map[string]string, pciIDsVendorsHint)
+	}
+	if len(p.pciSubsystems) == 0 {
+		p.pciSubsystems = make(map[string]map[string]string, pciIDsSubsystemsHint)
+	}
+
+	// Lines are read as bytes so the scanner buffer is reused, only the
+	// IDs and names that are kept get copied into strings.
+	scanner := bufio.NewScanner(r)
 	var currentVendor, currentDevice, currentBaseClass, currentSubclass string
+	var vendorDevices, deviceSubsystems map[string]string
 	var inClassContext bool
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" || strings.HasPrefix(line, "#") {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
+		tabs := 0
+		for tabs < len(line) && tabs < 3 && line[tabs] == '\t' {
+			tabs++
+		}
 
 		// Handle class lines (starts with 'C')
-		if strings.HasPrefix(line, "C ") {
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 {
-				classID := strings.TrimSpace(parts[0][1:]) // Remove 'C' prefix
-				className := strings.TrimSpace(parts[1])
-				p.pciClasses[classID] = className
+		if bytes.HasPrefix(line, []byte("C ")) {
+			if id, name, ok := splitPCIIDLine(line[1:]); ok { // Remove 'C' prefix
+				classID := string(id)
+				p.pciClasses[classID] = string(name)
 				currentBaseClass = classID
 				inClassContext = true
 			}
@@ -98,76 +324,70 @@ func (p *pciIDProvider) load(paths []string, customPath string) {
 		}
 
 		// Handle subclass lines (single tab after class)
-		if strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "\t\t") && inClassContext {
-			line = strings.TrimPrefix(line, "\t")
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 && currentBaseClass != "" {
-				subclassID := strings.TrimSpace(parts[0])
-				subclassName := strings.TrimSpace(parts[1])
+		if tabs == 1 && inClassContext {
+			id, name, ok := splitPCIIDLine(line[1:])
+			if ok && currentBaseClass != "" {
 				// Store as base class + subclass
-				fullClassID := currentBaseClass + subclassID
-				p.pciSubclasses[fullClassID] = subclassName
+				fullClassID := currentBaseClass + string(id)
+				p.pciSubclasses[fullClassID] = string(name)
 				currentSubclass = fullClassID
 			}
 			continue
 		}
 
 		// Handle programming interface lines (double tab after subclass)
-		if strings.HasPrefix(line, "\t\t") && !strings.HasPrefix(line, "\t\t\t") && inClassContext {
-			line = strings.TrimPrefix(line, "\t\t")
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 && currentSubclass != "" {
-				progIfID := strings.TrimSpace(parts[0])
-				progIfName := strings.TrimSpace(parts[1])
+		if tabs == 2 && inClassContext {
+			id, name, ok := splitPCIIDLine(line[2:])
+			if ok && currentSubclass != "" {
 				// Store as base class + subclass + programming interface
-				fullClassID := currentSubclass + progIfID
-				p.pciProgIfs[fullClassID] = progIfName
+				p.pciProgIfs[currentSubclass+string(id)] = string(name)
 			}
 			continue
 		}
 
-		// Handle vendor lines (no leading whitespace, not starting with 'C')
-		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "C ") {
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 {
-				currentVendor = strings.TrimSpace(parts[0])
-				p.pciVendors[currentVendor] = strings.TrimSpace(parts[1])
+		switch tabs {
+		case 0:
+			// Handle vendor lines (no leading whitespace, not starting with 'C')
+			if id, name, ok := splitPCIIDLine(line); ok {
+				currentVendor = string(id)
+				p.pciVendors[currentVendor] = string(name)
 				currentDevice = ""
+				vendorDevices = nil
 				inClassContext = false
 			}
-			continue
-		}
 
-		// Handle device lines (single tab)
-		if strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "\t\t") {
-			line = strings.TrimPrefix(line, "\t")
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 && currentVendor != "" {
-				currentDevice = strings.TrimSpace(parts[0])
-				if p.pciDevices[currentVendor] == nil {
-					p.pciDevices[currentVendor] = make(map[string]string)
+		case 1:
+			// Handle device lines (single tab)
+			id, name, ok := splitPCIIDLine(line[1:])
+			if ok && currentVendor != "" {
+				currentDevice = string(id)
+				if vendorDevices == nil {
+					vendorDevices = p.pciDevices[currentVendor]
+					if vendorDevices == nil {
+						vendorDevices = make(map[string]string)
+						p.pciDevices[currentVendor] = vendorDevices
+					}
 				}
-				p.pciDevices[currentVendor][currentDevice] = strings.TrimSpace(parts[1])
+				vendorDevices[currentDevice] = string(name)
+				deviceSubsystems = nil
 			}
-			continue
-		}
 
-		// Handle subsystem lines (double tab)
-		if strings.HasPrefix(line, "\t\t") {
-			line = strings.TrimPrefix(line, "\t\t")
-			parts := strings.SplitN(line, "  ", 2)
-			if len(parts) >= 2 && currentVendor != "" && currentDevice != "" {
-				subsysID := strings.TrimSpace(parts[0])
-				subsysName := strings.TrimSpace(parts[1])
-				key := fmt.Sprintf("%s:%s", currentVendor, currentDevice)
-				if p.pciSubsystems[key] == nil {
-					p.pciSubsystems[key] = make(map[string]string)
+		default:
+			// Handle subsystem lines (double tab)
+			id, name, ok := splitPCIIDLine(line[2:])
+			if ok && currentVendor != "" && currentDevice != "" {
+				if deviceSubsystems == nil {
+					key := currentVendor + ":" + currentDevice
+					deviceSubsystems = p.pciSubsystems[key]
+					if deviceSubsystems == nil {
+						deviceSubsystems = make(map[string]string)
+						p.pciSubsystems[key] = deviceSubsystems
+					}
 				}
 				// Convert subsystem ID from "vendor device" format to "vendor:device" format
-				subsysParts := strings.Fields(subsysID)
-				if len(subsysParts) == 2 {
-					subsysKey := fmt.Sprintf("%s:%s", subsysParts[0], subsysParts[1])
-					p.pciSubsystems[key][subsysKey] = subsysName
+				subVendor, subDevice, ok := bytes.Cut(id, []byte(" "))
+				if ok && len(subVendor) > 0 && len(subDevice) > 0 && !bytes.ContainsAny(subDevice, " \t") && !bytes.ContainsAny(subVendor, "\t") {
+					deviceSubsystems[string(subVendor)+":"+string(subDevice)] = string(name)
 				}
 			}
 		}
@@ -191,9 +411,14 @@ func (p *pciIDProvider) load(paths []string, customPath string) {
 		"subclasses", len(p.pciSubclasses),
 		"progIfs", len(p.pciProgIfs),
 	)
+
+	return scanner.Err()
 }
 
 func (p *pciIDProvider) getVendorName(vendorID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	vendorID = strings.ToLower(strings.TrimPrefix(vendorID, "0x"))
 	if name, ok := p.pciVendors[vendorID]; ok {
 		return name
@@ -202,6 +427,9 @@ func (p *pciIDProvider) getVendorName(vendorID string) string {
 }
 
 func (p *pciIDProvider) getDeviceName(vendorID, deviceID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	vendorID = strings.ToLower(strings.TrimPrefix(vendorID, "0x"))
 	deviceID = strings.ToLower(strings.TrimPrefix(deviceID, "0x"))
 
@@ -214,6 +442,9 @@ func (p *pciIDProvider) getDeviceName(vendorID, deviceID string) string {
 }
 
 func (p *pciIDProvider) getSubsystemName(vendorID, deviceID, subsysVendorID, subsysDeviceID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	vendorID = strings.ToLower(strings.TrimPrefix(vendorID, "0x"))
 	deviceID = strings.ToLower(strings.TrimPrefix(deviceID, "0x"))
 	subsysVendorID = strings.ToLower(strings.TrimPrefix(subsysVendorID, "0x"))
@@ -231,6 +462,9 @@ func (p *pciIDProvider) getSubsystemName(vendorID, deviceID, subsysVendorID, sub
 }
 
 func (p *pciIDProvider) getClassName(classID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	classID = strings.ToLower(strings.TrimPrefix(classID, "0x"))
 
 	// Try to find the programming interface first (6 digits)
@@ -0,0 +1,29 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build embedpciids
+
+package collector
+
+import (
+	_ "embed"
+)
+
+// Run `make pci-ids` to fetch the database before building with this tag.
+//
+//go:embed pci.ids
+var embeddedPCIIDsData []byte
+
+func init() {
+	embeddedPCIIDs = embeddedPCIIDsData
+}
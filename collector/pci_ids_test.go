@@ -0,0 +1,401 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPCIIDs = `# Comment line
+10de  NVIDIA Corporation
+	2330  GH100 [H100 SXM5 80GB]
+		10de 16c1  H100 SXM5 80GB
+	2684  AD102 [GeForce RTX 4090]
+		1043 889d  TUF Gaming GeForce RTX 4090
+		1458 4104  GeForce RTX 4090 Gaming OC
+
+1002  Advanced Micro Devices, Inc. [AMD/ATI]
+	74a1  Aqua Vanjaram [Instinct MI300X]
+
+C 03  Display controller
+	00  VGA compatible controller
+		00  VGA controller
+		01  8514 controller
+	02  3D controller
+C 04  Multimedia controller
+	03  Audio device
+`
+
+func newTestPCIIDProvider(t *testing.T, data string) *pciIDProvider {
+	t.Helper()
+	p := newPCIIDProvider(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, "", "", false, 0)
+	if err := p.parse(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPCIIDParseVendors(t *testing.T) {
+	p := newTestPCIIDProvider(t, testPCIIDs)
+
+	if got, want := len(p.pciVendors), 2; got != want {
+		t.Fatalf("got %d vendors, want %d", got, want)
+	}
+	for id, want := range map[string]string{
+		"0x10de": "NVIDIA Corporation",
+		"1002":   "Advanced Micro Devices, Inc. [AMD/ATI]",
+		"0x8086": "8086",
+	} {
+		if got := p.getVendorName(id); got != want {
+			t.Errorf("vendor %s: got %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestPCIIDParseDevices(t *testing.T) {
+	p := newTestPCIIDProvider(t, testPCIIDs)
+
+	for _, tc := range []struct {
+		vendor, device, want string
+	}{
+		{"0x10de", "0x2330", "GH100 [H100 SXM5 80GB]"},
+		{"0x10DE", "0x2684", "AD102 [GeForce RTX 4090]"},
+		{"0x1002", "0x74a1", "Aqua Vanjaram [Instinct MI300X]"},
+		// Device IDs are scoped to their vendor.
+		{"0x1002", "0x2330", "2330"},
+	} {
+		if got := p.getDeviceName(tc.vendor, tc.device); got != tc.want {
+			t.Errorf("device %s:%s: got %q, want %q", tc.vendor, tc.device, got, tc.want)
+		}
+	}
+}
+
+func TestPCIIDParseSubsystems(t *testing.T) {
+	p := newTestPCIIDProvider(t, testPCIIDs)
+
+	if got, want := len(p.pciSubsystems["10de:2684"]), 2; got != want {
+		t.Fatalf("got %d subsystems, want %d", got, want)
+	}
+	for _, tc := range []struct {
+		vendor, device, subVendor, subDevice, want string
+	}{
+		{"0x10de", "0x2330", "0x10de", "0x16c1", "H100 SXM5 80GB"},
+		{"0x10de", "0x2684", "0x1043", "0x889d", "TUF Gaming GeForce RTX 4090"},
+		{"0x10de", "0x2684", "0x1458", "0x4104", "GeForce RTX 4090 Gaming OC"},
+		{"0x10de", "0x2684", "0x1462", "0x5103", "5103"},
+	} {
+		if got := p.getSubsystemName(tc.vendor, tc.device, tc.subVendor, tc.subDevice); got != tc.want {
+			t.Errorf("subsystem %s:%s: got %q, want %q", tc.subVendor, tc.subDevice, got, tc.want)
+		}
+	}
+}
+
+func TestPCIIDParseClasses(t *testing.T) {
+	p := newTestPCIIDProvider(t, testPCIIDs)
+
+	for id, want := range map[string]string{
+		"0x030000": "VGA controller",
+		"0x030001": "8514 controller",
+		"0x030200": "3D controller",
+		"0x040300": "Audio device",
+		"0x038000": "Display controller",
+		"0x120000": "Unknown class (120000)",
+	} {
+		if got := p.getClassName(id); got != want {
+			t.Errorf("class %s: got %q, want %q", id, got, want)
+		}
+	}
+	// Class programming interfaces must not leak into the vendor tables.
+	if _, ok := p.pciDevices["03"]; ok {
+		t.Error("class entries parsed as devices")
+	}
+}
+
+func TestPCIIDProviderEmbedded(t *testing.T) {
+	data, err := os.ReadFile("fixtures/pci.ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := embeddedPCIIDs
+	embeddedPCIIDs = data
+	defer func() { embeddedPCIIDs = orig }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	p := newPCIIDProvider(logger, []string{"/nonexistent/pci.ids"}, "", "", false, 0)
+	if got := p.getVendorName("0x8086"); got != "8086" {
+		t.Errorf("embedded data used without being enabled, got vendor %q", got)
+	}
+
+	p = newPCIIDProvider(logger, []string{"/nonexistent/pci.ids"}, "", "", true, 0)
+	if got, want := p.getVendorName("0x8086"), "Intel Corporation"; got != want {
+		t.Errorf("got vendor %q, want %q", got, want)
+	}
+	if got, want := p.getDeviceName("0x8086", "0x1521"), "I350 Gigabit Network Connection"; got != want {
+		t.Errorf("got device %q, want %q", got, want)
+	}
+}
+
+func TestPCIIDProviderRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	if err := os.WriteFile(path, []byte("8086  Intel Corporation\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPCIIDProvider(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, path, "", false, 10*time.Millisecond)
+	defer p.stop()
+	if got, want := p.getVendorName("0x8086"), "Intel Corporation"; got != want {
+		t.Fatalf("got vendor %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("8086  Intel Corp.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.getVendorName("0x8086") != "Intel Corp." {
+		if time.Now().After(deadline) {
+			t.Fatalf("pci.ids not reloaded, vendor still %q", p.getVendorName("0x8086"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPCIIDProviderReloadFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	if err := os.WriteFile(path, []byte("8086  Intel Corporation\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p := newPCIIDProvider(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, path, "", false, 0)
+
+	// Gone, e.g. while a package upgrade replaces it, and then truncated.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	p.Reload()
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p.Reload()
+
+	if got, want := p.getVendorName("0x8086"), "Intel Corporation"; got != want {
+		t.Errorf("got vendor %q, want %q", got, want)
+	}
+	if got := p.getSource(); got != path {
+		t.Errorf("got source %q, want %q", got, path)
+	}
+}
+
+func TestPCIIDProviderSource(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pci.ids")
+	if err := os.WriteFile(path, []byte("8086  Intel Corporation\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := *rootfsPath
+	*rootfsPath = dir
+	defer func() { *rootfsPath = orig }()
+
+	for _, tc := range []struct {
+		name       string
+		paths      []string
+		customPath string
+		want       string
+	}{
+		{"custom", []string{"/pci.ids"}, "fixtures/pci.ids", "fixtures/pci.ids"},
+		{"default", []string{"/nonexistent/pci.ids", "/pci.ids"}, "", path},
+		{"none", []string{"/nonexistent/pci.ids"}, "", ""},
+	} {
+		p := newPCIIDProvider(logger, tc.paths, tc.customPath, "", false, 0)
+		if got := p.getSource(); got != tc.want {
+			t.Errorf("%s: got source %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPCIIDProviderDir(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"10-base.ids":  "10de  NVIDIA Corporation\n\t2330  GH100 [H100 SXM5 80GB]\n",
+		"20-local.ids": "10de  NVIDIA (local)\n\t2684  AD102 [GeForce RTX 4090]\n",
+		// Not an .ids file, ignored.
+		"README": "10de  Ignored\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newPCIIDProvider(logger, nil, "", dir, false, 0)
+	if got, want := p.getVendorName("10de"), "NVIDIA (local)"; got != want {
+		t.Errorf("got vendor %q, want %q from the later file", got, want)
+	}
+	for deviceID, want := range map[string]string{
+		"2330": "GH100 [H100 SXM5 80GB]",
+		"2684": "AD102 [GeForce RTX 4090]",
+	} {
+		if got := p.getDeviceName("10de", deviceID); got != want {
+			t.Errorf("got device %q, want %q", got, want)
+		}
+	}
+	if got := p.getSource(); got != dir {
+		t.Errorf("got source %q, want %q", got, dir)
+	}
+
+	// The directory is merged over the pci.ids file.
+	p = newPCIIDProvider(logger, nil, "fixtures/pci.ids", dir, false, 0)
+	if got, want := p.getVendorName("10de"), "NVIDIA (local)"; got != want {
+		t.Errorf("got vendor %q, want %q from the directory", got, want)
+	}
+	if got, want := p.getVendorName("8086"), "Intel Corporation"; got != want {
+		t.Errorf("got vendor %q, want %q from the pci.ids file", got, want)
+	}
+	if got := p.getSource(); got != "fixtures/pci.ids" {
+		t.Errorf("got source %q, want fixtures/pci.ids", got)
+	}
+}
+
+func TestPCIIDProviderAge(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "pci.ids")
+	if err := os.WriteFile(path, []byte("8086  Intel Corporation\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPCIIDProvider(logger, nil, path, "", false, 0)
+	age, ok := p.getAge(mtime.Add(36 * time.Hour))
+	if !ok {
+		t.Fatal("expected an age for the loaded file")
+	}
+	if want := 36 * time.Hour; age != want {
+		t.Errorf("got age %v, want %v", age, want)
+	}
+
+	// The embedded copy has no age.
+	p = newPCIIDProvider(logger, []string{"/nonexistent/pci.ids"}, "", "", true, 0)
+	if _, ok := p.getAge(time.Now()); ok {
+		t.Error("expected no age for the embedded copy")
+	}
+}
+
+// pciIDCounts returns the number of entries of each lookup table.
+func pciIDCounts(p *pciIDProvider) map[string]int {
+	counts := map[string]int{
+		"vendors":    len(p.pciVendors),
+		"classes":    len(p.pciClasses),
+		"subclasses": len(p.pciSubclasses),
+		"progIfs":    len(p.pciProgIfs),
+	}
+	for _, devices := range p.pciDevices {
+		counts["devices"] += len(devices)
+	}
+	for _, subsystems := range p.pciSubsystems {
+		counts["subsystems"] += len(subsystems)
+	}
+	return counts
+}
+
+// generatePCIIDs returns a pci.ids file with roughly the size and shape of
+// the upstream one.
+func generatePCIIDs() []byte {
+	var b strings.Builder
+	b.WriteString("# Generated for testing\n\n")
+	for v := range 2500 {
+		fmt.Fprintf(&b, "%04x  Vendor %d Corporation\n", v, v)
+		for d := range v % 25 {
+			fmt.Fprintf(&b, "\t%04x  Device %d of vendor %d [Example]\n", d, d, v)
+			for s := range d % 4 {
+				fmt.Fprintf(&b, "\t\t%04x %04x  Subsystem %d of device %d\n", v, s, s, d)
+			}
+		}
+	}
+	for c := range 20 {
+		fmt.Fprintf(&b, "C %02x  Class %d\n", c, c)
+		for s := range 8 {
+			fmt.Fprintf(&b, "\t%02x  Subclass %d\n", s, s)
+			for i := range 3 {
+				fmt.Fprintf(&b, "\t\t%02x  Programming interface %d\n", i, i)
+			}
+		}
+	}
+	return []byte(b.String())
+}
+
+func TestPCIIDParseCounts(t *testing.T) {
+	fixture, err := os.ReadFile("fixtures/pci.ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want map[string]int
+	}{
+		{
+			name: "fixture",
+			data: fixture,
+			want: map[string]int{"vendors": 5, "devices": 5, "subsystems": 5, "classes": 4, "subclasses": 6, "progIfs": 1},
+		},
+		{
+			name: "generated",
+			data: generatePCIIDs(),
+			want: map[string]int{"vendors": 2500, "devices": 30000, "subsystems": 42000, "classes": 20, "subclasses": 160, "progIfs": 480},
+		},
+		{
+			name: "inline",
+			data: []byte(testPCIIDs),
+			want: map[string]int{"vendors": 2, "devices": 3, "subsystems": 3, "classes": 2, "subclasses": 3, "progIfs": 2},
+		},
+	} {
+		p := newTestPCIIDProvider(t, string(tc.data))
+		if got := pciIDCounts(p); !maps.Equal(got, tc.want) {
+			t.Errorf("%s: got counts %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkPCIIDsLoad parses collector/pci.ids as fetched by `make pci-ids`,
+// or a generated file of the same size if it's missing.
+func BenchmarkPCIIDsLoad(b *testing.B) {
+	data, err := os.ReadFile("pci.ids")
+	if err != nil {
+		data = generatePCIIDs()
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		p := newEmptyPCIIDProvider(logger, nil, "", "", false)
+		if err := p.parse(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
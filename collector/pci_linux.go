@@ -0,0 +1,662 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"weak"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// The PCI ID database flags are used by both the pcidevice and gpu collectors.
+var (
+	pciIdsFile     = kingpin.Flag("collector.pcidevice.idsfile", "Path to pci.ids file to use for PCI device identification.").String()
+	pciIdsDir      = kingpin.Flag("collector.pcidevice.ids-dir", "Directory of split PCI ID database files. Every *.ids file in it is merged over the pci.ids file in lexical order, later files overriding earlier ones.").String()
+	pciIdsEmbedded = kingpin.Flag("collector.pcidevice.embedded-ids", "Fall back to the pci.ids copy embedded at build time when no pci.ids file is found.").Default("false").Bool()
+	pciIdsRefresh  = kingpin.Flag("collector.pcidevice.ids-refresh-interval", "Interval at which to reload the pci.ids file, 0 disables reloading.").Default("0s").Duration()
+)
+
+// pciIDProviderConfig is the configuration a shared pciIDProvider was
+// loaded with.
+type pciIDProviderConfig struct {
+	file, dir string
+	embedded  bool
+	refresh   time.Duration
+}
+
+// sharedPCIIDs caches the provider returned by sharedPCIIDProvider. It's
+// only held weakly, like by the refresh loop, so that the provider goes away
+// with the last collector using it.
+var sharedPCIIDs struct {
+	mu       sync.Mutex
+	config   pciIDProviderConfig
+	provider weak.Pointer[pciIDProvider]
+}
+
+// sharedPCIIDProvider returns the PCI ID database configured by the
+// --collector.pcidevice.ids* flags. The collectors resolving names share it
+// so that pci.ids is parsed, and refreshed, only once.
+func sharedPCIIDProvider(logger *slog.Logger) *pciIDProvider {
+	config := pciIDProviderConfig{
+		file:     *pciIdsFile,
+		dir:      *pciIdsDir,
+		embedded: *pciIdsEmbedded,
+		refresh:  *pciIdsRefresh,
+	}
+
+	sharedPCIIDs.mu.Lock()
+	defer sharedPCIIDs.mu.Unlock()
+	if p := sharedPCIIDs.provider.Value(); p != nil && sharedPCIIDs.config == config {
+		return p
+	}
+	p := newPCIIDProvider(logger, pciIdsPaths, config.file, config.dir, config.embedded, config.refresh)
+	sharedPCIIDs.config = config
+	sharedPCIIDs.provider = weak.Make(p)
+	return p
+}
+
+// Offsets and IDs of the PCI configuration space used to find the PCI
+// Express capability.
+const (
+	pciStatusOffset         = 0x06
+	pciHeaderTypeOffset     = 0x0e
+	pciHeaderTypeMultiFunc  = 0x80
+	pciStatusCapList        = 0x10
+	pciCapPointerOffset     = 0x34
+	pciCapIDPM              = 0x01
+	pciCapIDExp             = 0x10
+	pciPMCOffset            = 0x02
+	pciPMCPMESupportShift   = 11
+	pciExpFlagsOffset       = 0x02
+	pciExpFlagsSlot         = 0x0100
+	pciExpDevCtlOffset      = 0x08
+	pciExpDevCtlExtTag      = 0x0100
+	pciExpDevStaOffset      = 0x0a
+	pciExpDevStaTrPnd       = 0x0020
+	pciExpLnkCapOffset      = 0x0c
+	pciExpLnkCapSpeed       = 0x000f
+	pciExpLnkCtlOffset      = 0x10
+	pciExpLnkCtlASPM        = 0x0003
+	pciExpSltCapOffset      = 0x14
+	pciExpSltCapAttnInd     = 0x0008
+	pciExpSltCapPwrInd      = 0x0010
+	pciExpSltCtlOffset      = 0x18
+	pciExpSltCtlAttnIndPos  = 6
+	pciExpSltCtlPwrIndPos   = 8
+	pciExpDevCap2Offset     = 0x24
+	pciExpDevCap2AtomicRout = 0x0040
+	pciExpDevCap2AtomicComp = 0x0380 // 32 bit, 64 bit and 128 bit CAS completer
+	pciExpDevCtl2Offset     = 0x28
+	pciExpDevCtl2CTOValue   = 0x000f
+	pciExpDevCtl2CTODisable = 0x0010
+	pciExpDevCtl2AtomicReq  = 0x0040
+	pciExpDevCtl2TenBitTag  = 0x1000 // 10-Bit Tag Requester Enable
+
+	pciExtCapOffset          = 0x100
+	pciExtCapIDDSN           = 0x0003
+	pciDSNSerialOffset       = 0x04
+	pciExtCapIDSecondaryPCIe = 0x0019
+	pciSecPCIeLaneErrStatus  = 0x08
+)
+
+// errNoPCIeCapability is returned for devices without a PCI Express
+// capability in the readable part of their config space.
+var errNoPCIeCapability = errors.New("no PCI Express capability")
+
+// pcieLinkSpeeds maps the Max Link Speed encodings of the Link Capabilities
+// register, PCIe 1.0 to 6.0, to transfers per second. The encoding is also
+// the PCIe generation running at that speed.
+var pcieLinkSpeeds = map[uint16]float64{
+	1: 2.5e9,
+	2: 5e9,
+	3: 8e9,
+	4: 16e9,
+	5: 32e9,
+	6: 64e9,
+}
+
+// errShortConfigSpace is returned for config spaces too short to hold the
+// PCI header.
+var errShortConfigSpace = errors.New("config space too short")
+
+// errNoPMCapability is returned for devices without a Power Management
+// capability in the readable part of their config space.
+var errNoPMCapability = errors.New("no Power Management capability")
+
+// pciPMEStates are the device power states in the order of the PME_Support
+// bits of the Power Management Capabilities register.
+var pciPMEStates = []string{"d0", "d1", "d2", "d3hot", "d3cold"}
+
+// errNoPCIeSlot is returned for PCIe ports without a slot.
+var errNoPCIeSlot = errors.New("no PCI Express slot")
+
+// pcieIndicatorStates maps the attention and power indicator control values
+// of the Slot Control register to their state, 0 is reserved.
+var pcieIndicatorStates = map[uint16]string{
+	1: "on",
+	2: "blink",
+	3: "off",
+}
+
+// errNoLaneErrorStatus is returned for devices without a Secondary PCI
+// Express extended capability in the readable part of their config space.
+var errNoLaneErrorStatus = errors.New("no Lane Error Status register")
+
+// errNoDeviceSerialNumber is returned for devices without a Device Serial
+// Number extended capability in the readable part of their config space.
+var errNoDeviceSerialNumber = errors.New("no Device Serial Number capability")
+
+// pciMaxTopologyDepth bounds the walk towards the root complex so that a
+// looping sysfs tree can't hang the collector.
+const pciMaxTopologyDepth = 32
+
+// Helpers shared by the collectors reading /sys/bus/pci/devices.
+
+// readIOMMUGroup returns the IOMMU group number of the PCI device at
+// devicePath, or "-1" if the device is not part of a group.
+func readIOMMUGroup(devicePath string) string {
+	target, err := os.Readlink(filepath.Join(devicePath, "iommu_group"))
+	if err != nil {
+		return "-1"
+	}
+	return filepath.Base(target)
+}
+
+// readNumaNode returns the NUMA node of the PCI device at devicePath, or -1
+// if it's unknown: the kernel reports -1 for devices without NUMA affinity
+// and doesn't create the attribute without CONFIG_NUMA.
+func readNumaNode(devicePath string) float64 {
+	data, err := os.ReadFile(filepath.Join(devicePath, "numa_node"))
+	if err != nil {
+		return -1
+	}
+	node, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil || node < 0 {
+		return -1
+	}
+	return float64(node)
+}
+
+// countBoundVFs returns the number of SR-IOV virtual functions of the
+// physical function at devicePath that are bound to a driver, found through
+// its virtfnN links.
+func countBoundVFs(devicePath string) (int, error) {
+	vfs, err := filepath.Glob(filepath.Join(devicePath, "virtfn*"))
+	if err != nil {
+		return 0, err
+	}
+	var bound int
+	for _, vf := range vfs {
+		if _, err := os.Stat(filepath.Join(vf, "driver")); err == nil {
+			bound++
+		}
+	}
+	return bound, nil
+}
+
+// isPCIVirtualFunction reports whether the device at devicePath is an SR-IOV
+// virtual function, which links to its physical function through physfn.
+func isPCIVirtualFunction(devicePath string) bool {
+	_, err := os.Lstat(filepath.Join(devicePath, "physfn"))
+	return err == nil
+}
+
+// readPCIAutosuspendDelay returns the runtime PM autosuspend delay of the
+// PCI device at devicePath in seconds, or -1 if autosuspend is disabled, as
+// signalled by a negative power/autosuspend_delay_ms.
+func readPCIAutosuspendDelay(devicePath string) (float64, error) {
+	data, err := os.ReadFile(filepath.Join(devicePath, "power", "autosuspend_delay_ms"))
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ms < 0 {
+		return -1, nil
+	}
+	return float64(ms) / 1e3, nil
+}
+
+// pciFirmwareVersionFiles are the sysfs attributes holding the firmware
+// version of a PCI device, relative to the device directory, in the order
+// they're tried: the driver's own attribute, the revision of an NVMe
+// controller and the firmware of an InfiniBand/RDMA device.
+var pciFirmwareVersionFiles = []string{
+	"fw_version",
+	filepath.Join("nvme", "nvme*", "firmware_rev"),
+	filepath.Join("infiniband", "*", "fw_ver"),
+}
+
+// readPCIFirmwareVersion returns the firmware version the driver reports for
+// the PCI device at devicePath, os.ErrNotExist if it reports none.
+func readPCIFirmwareVersion(devicePath string) (string, error) {
+	for _, pattern := range pciFirmwareVersionFiles {
+		files, err := filepath.Glob(filepath.Join(devicePath, pattern))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			if version, err := readSysfsFile(file); err == nil && version != "" {
+				return version, nil
+			}
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// readPCIResetMethods returns the reset methods the kernel can use for the
+// PCI device at devicePath, e.g. flr or bus, in the order it tries them.
+func readPCIResetMethods(devicePath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(devicePath, "reset_method"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// readPCITopologyDepth returns the number of bridges between the PCI device
+// at devicePath and its root complex, found by walking up the resolved sysfs
+// path until the pci<segment>:<bus> root directory.
+func readPCITopologyDepth(devicePath string) (int, error) {
+	dir, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return 0, err
+	}
+	for depth := 0; depth < pciMaxTopologyDepth; depth++ {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, fmt.Errorf("no PCI root complex above %q", devicePath)
+		}
+		if strings.HasPrefix(filepath.Base(parent), "pci") {
+			return depth, nil
+		}
+		dir = parent
+	}
+	return 0, fmt.Errorf("PCI topology of %q deeper than %d levels", devicePath, pciMaxTopologyDepth)
+}
+
+// readPCIErrorState reports whether the PCI device at devicePath is in an
+// error state. The signals checked are:
+//
+//   - power/runtime_status is "error", runtime PM of the device failed and
+//     the driver no longer suspends or resumes it.
+//   - broken_parity_status is non-zero, the device was flagged as generating
+//     bogus parity errors.
+//
+// os.ErrNotExist is returned if none of the signals is available.
+func readPCIErrorState(devicePath string) (bool, error) {
+	found := false
+	if status, err := readSysfsFile(filepath.Join(devicePath, "power", "runtime_status")); err == nil {
+		found = true
+		if status == "error" {
+			return true, nil
+		}
+	}
+	if parity, err := readSysfsFile(filepath.Join(devicePath, "broken_parity_status")); err == nil {
+		found = true
+		if parity != "0" {
+			return true, nil
+		}
+	}
+	if !found {
+		return false, os.ErrNotExist
+	}
+	return false, nil
+}
+
+// pcieAERSeverities are the severities of node_pcidevice_aer_errors_total
+// with the aer_dev_* file and its total line, and the aer_rootport_total_err_*
+// file of root ports.
+var pcieAERSeverities = []struct {
+	severity, devFile, totalField, rootPortFile string
+}{
+	{"correctable", "aer_dev_correctable", "TOTAL_ERR_COR", "aer_rootport_total_err_cor"},
+	{"fatal", "aer_dev_fatal", "TOTAL_ERR_FATAL", "aer_rootport_total_err_fatal"},
+	{"nonfatal", "aer_dev_nonfatal", "TOTAL_ERR_NONFATAL", "aer_rootport_total_err_nonfatal"},
+}
+
+// readPCIeAERErrors returns the number of errors the AER driver logged for
+// the PCIe device at devicePath by severity, from the total lines of its
+// aer_dev_* files. Bridges, with rootPort set, fall back to the
+// aer_rootport_total_err_* files, which count the error messages the root
+// port received from the devices below it, including those without AER
+// statistics of their own. os.ErrNotExist is returned if the device has no
+// AER statistics.
+func readPCIeAERErrors(devicePath string, rootPort bool) (map[string]float64, error) {
+	errs := make(map[string]float64, len(pcieAERSeverities))
+	for _, s := range pcieAERSeverities {
+		data, err := os.ReadFile(filepath.Join(devicePath, s.devFile))
+		if err == nil {
+			for line := range strings.Lines(string(data)) {
+				field, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+				if !ok || field != s.totalField {
+					continue
+				}
+				n, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s %s count %q: %w", s.devFile, field, value, err)
+				}
+				errs[s.severity] = float64(n)
+			}
+		}
+		if _, ok := errs[s.severity]; ok || !rootPort {
+			continue
+		}
+		n, err := readUintFromFile(filepath.Join(devicePath, s.rootPortFile))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		errs[s.severity] = float64(n)
+	}
+	if len(errs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return errs, nil
+}
+
+// readPCIeSurpriseDownErrors returns the number of Surprise Down errors the
+// AER driver logged for the PCIe port at devicePath, the SDES lines of
+// aer_dev_fatal and aer_dev_nonfatal depending on the configured severity.
+// os.ErrNotExist is returned if the port has no AER statistics.
+func readPCIeSurpriseDownErrors(devicePath string) (float64, error) {
+	found := false
+	var total float64
+	for _, name := range []string{"aer_dev_fatal", "aer_dev_nonfatal"} {
+		data, err := os.ReadFile(filepath.Join(devicePath, name))
+		if err != nil {
+			continue
+		}
+		for line := range strings.Lines(string(data)) {
+			field, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+			if !ok || field != "SDES" {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s SDES count %q: %w", name, value, err)
+			}
+			found = true
+			total += float64(n)
+		}
+	}
+	if !found {
+		return 0, os.ErrNotExist
+	}
+	return total, nil
+}
+
+// parsePCIMultifunction returns whether the device is part of a
+// multifunction device, from the top bit of the Header Type register in
+// config, the raw PCI configuration space.
+func parsePCIMultifunction(config []byte) (bool, error) {
+	if len(config) < pciHeaderTypeOffset+1 {
+		return false, errShortConfigSpace
+	}
+	return config[pciHeaderTypeOffset]&pciHeaderTypeMultiFunc != 0, nil
+}
+
+// findPCICapability returns the offset of the capability with the given ID
+// in config, the raw PCI configuration space.
+func findPCICapability(config []byte, id byte) (int, bool) {
+	if len(config) < pciCapPointerOffset+1 {
+		return 0, false
+	}
+	if binary.LittleEndian.Uint16(config[pciStatusOffset:])&pciStatusCapList == 0 {
+		return 0, false
+	}
+	// Capabilities live in the 192 bytes after the header, 48 is enough to
+	// visit all of them without looping forever on a corrupt list.
+	ptr := int(config[pciCapPointerOffset]) &^ 0x3
+	for i := 0; i < 48 && ptr != 0; i++ {
+		if ptr+2 > len(config) {
+			return 0, false
+		}
+		if config[ptr] == id {
+			return ptr, true
+		}
+		ptr = int(config[ptr+1]) &^ 0x3
+	}
+	return 0, false
+}
+
+// findPCIExtCapability returns the offset of the extended capability with
+// the given ID in config, the raw PCI configuration space. The extended
+// config space is only readable by root.
+func findPCIExtCapability(config []byte, id uint16) (int, bool) {
+	// Extended capabilities are at least 4 bytes apart, bound the walk so a
+	// corrupt list can't loop forever.
+	ptr := pciExtCapOffset
+	for i := 0; i < (4096-pciExtCapOffset)/4 && ptr >= pciExtCapOffset; i++ {
+		if ptr+4 > len(config) {
+			return 0, false
+		}
+		header := binary.LittleEndian.Uint32(config[ptr:])
+		if header == 0 || header == 0xffffffff {
+			return 0, false
+		}
+		if uint16(header) == id {
+			return ptr, true
+		}
+		ptr = int(header>>20) &^ 0x3
+	}
+	return 0, false
+}
+
+// findPCIeCapability returns the offset of the PCI Express capability in
+// config, the raw PCI configuration space.
+func findPCIeCapability(config []byte) (int, error) {
+	ptr, ok := findPCICapability(config, pciCapIDExp)
+	if !ok {
+		return 0, errNoPCIeCapability
+	}
+	return ptr, nil
+}
+
+// parsePCIPMESupport returns whether the device can signal PME from each of
+// pciPMEStates, decoded from the PME_Support bits of the Power Management
+// Capabilities register found in config, the raw PCI configuration space.
+func parsePCIPMESupport(config []byte) (map[string]bool, error) {
+	ptr, ok := findPCICapability(config, pciCapIDPM)
+	if !ok || ptr+pciPMCOffset+2 > len(config) {
+		return nil, errNoPMCapability
+	}
+	pmc := binary.LittleEndian.Uint16(config[ptr+pciPMCOffset:])
+	supported := make(map[string]bool, len(pciPMEStates))
+	for i, state := range pciPMEStates {
+		supported[state] = pmc&(1<<(pciPMCPMESupportShift+i)) != 0
+	}
+	return supported, nil
+}
+
+// readPCIeRegister returns the 16 bit register at offset in the PCI Express
+// capability found in config.
+func readPCIeRegister(config []byte, offset int) (uint16, error) {
+	ptr, err := findPCIeCapability(config)
+	if err != nil {
+		return 0, err
+	}
+	off := ptr + offset
+	if off+2 > len(config) {
+		return 0, errNoPCIeCapability
+	}
+	return binary.LittleEndian.Uint16(config[off:]), nil
+}
+
+// parsePCIeDeviceControl returns the Device Control register of the PCI
+// Express capability found in config, the raw PCI configuration space.
+func parsePCIeDeviceControl(config []byte) (uint16, error) {
+	return readPCIeRegister(config, pciExpDevCtlOffset)
+}
+
+// parsePCIeDeviceControl2 returns the Device Control 2 register of the PCI
+// Express capability found in config, the raw PCI configuration space.
+func parsePCIeDeviceControl2(config []byte) (uint16, error) {
+	return readPCIeRegister(config, pciExpDevCtl2Offset)
+}
+
+// parsePCIeDeviceStatus returns the Device Status register of the PCI Express
+// capability found in config, the raw PCI configuration space.
+func parsePCIeDeviceStatus(config []byte) (uint16, error) {
+	return readPCIeRegister(config, pciExpDevStaOffset)
+}
+
+// parsePCIeDeviceCapabilities2 returns the low word of the Device
+// Capabilities 2 register of the PCI Express capability found in config, the
+// raw PCI configuration space. It holds the AtomicOp routing and completer
+// support bits.
+func parsePCIeDeviceCapabilities2(config []byte) (uint16, error) {
+	return readPCIeRegister(config, pciExpDevCap2Offset)
+}
+
+// parsePCIeSlotIndicators returns the state of the attention and power
+// indicators (LEDs) of the slot below the PCIe port, decoded from the Slot
+// Control register of the PCI Express capability found in config, the raw PCI
+// configuration space. The state of an indicator the slot doesn't have is
+// empty.
+func parsePCIeSlotIndicators(config []byte) (attention, power string, err error) {
+	flags, err := readPCIeRegister(config, pciExpFlagsOffset)
+	if err != nil {
+		return "", "", err
+	}
+	if flags&pciExpFlagsSlot == 0 {
+		return "", "", errNoPCIeSlot
+	}
+	// The indicator present bits are in the low word of the 32 bit register.
+	sltCap, err := readPCIeRegister(config, pciExpSltCapOffset)
+	if err != nil {
+		return "", "", err
+	}
+	sltCtl, err := readPCIeRegister(config, pciExpSltCtlOffset)
+	if err != nil {
+		return "", "", err
+	}
+	if sltCap&pciExpSltCapAttnInd != 0 {
+		attention = pcieIndicatorStates[sltCtl>>pciExpSltCtlAttnIndPos&0x3]
+	}
+	if sltCap&pciExpSltCapPwrInd != 0 {
+		power = pcieIndicatorStates[sltCtl>>pciExpSltCtlPwrIndPos&0x3]
+	}
+	return attention, power, nil
+}
+
+// parsePCIeLinkCapabilitiesSpeed returns the Max Link Speed the hardware
+// supports in transfers per second, decoded from the Link Capabilities
+// register of the PCI Express capability found in config. Unlike sysfs
+// max_link_speed it isn't lowered by firmware limiting the link.
+func parsePCIeLinkCapabilitiesSpeed(config []byte) (float64, error) {
+	// Max Link Speed is in the low word of the 32 bit register.
+	lnkCap, err := readPCIeRegister(config, pciExpLnkCapOffset)
+	if err != nil {
+		return 0, err
+	}
+	speed, ok := pcieLinkSpeeds[lnkCap&pciExpLnkCapSpeed]
+	if !ok {
+		return 0, fmt.Errorf("unknown PCIe max link speed encoding %#x", lnkCap&pciExpLnkCapSpeed)
+	}
+	return speed, nil
+}
+
+// pcieGeneration returns the PCIe generation of a link running at speed
+// transfers per second, or "unknown" for speeds no generation runs at.
+func pcieGeneration(speed float64) string {
+	for encoding, s := range pcieLinkSpeeds {
+		if s == speed {
+			return strconv.Itoa(int(encoding))
+		}
+	}
+	return "unknown"
+}
+
+// parsePCIeLinkControl returns the Link Control register of the PCI Express
+// capability found in config, the raw PCI configuration space.
+func parsePCIeLinkControl(config []byte) (uint16, error) {
+	return readPCIeRegister(config, pciExpLnkCtlOffset)
+}
+
+// parsePCIeASPMPolicy returns the active policy from the content of
+// /sys/module/pcie_aspm/parameters/policy, which lists all policies with the
+// active one in brackets:
+//
+//	[default] performance powersave powersupersave
+func parsePCIeASPMPolicy(data string) (string, error) {
+	for _, policy := range strings.Fields(data) {
+		if active, ok := strings.CutPrefix(policy, "["); ok {
+			if active, ok := strings.CutSuffix(active, "]"); ok && active != "" {
+				return active, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no active ASPM policy in %q", data)
+}
+
+// parsePCIeLaneErrorStatus returns the Lane Error Status register of the
+// Secondary PCI Express extended capability found in config, one bit per
+// lane that detected an error since the bits were last cleared. The extended
+// config space is only readable by root.
+func parsePCIeLaneErrorStatus(config []byte) (uint32, error) {
+	ptr, ok := findPCIExtCapability(config, pciExtCapIDSecondaryPCIe)
+	if !ok || ptr+pciSecPCIeLaneErrStatus+4 > len(config) {
+		return 0, errNoLaneErrorStatus
+	}
+	return binary.LittleEndian.Uint32(config[ptr+pciSecPCIeLaneErrStatus:]), nil
+}
+
+// parsePCIeDeviceSerialNumber returns the Device Serial Number extended
+// capability found in config formatted like lspci, the eight bytes of the
+// 64 bit serial in hex from the most significant one, separated by dashes.
+func parsePCIeDeviceSerialNumber(config []byte) (string, error) {
+	ptr, ok := findPCIExtCapability(config, pciExtCapIDDSN)
+	if !ok || ptr+pciDSNSerialOffset+8 > len(config) {
+		return "", errNoDeviceSerialNumber
+	}
+	serial := binary.LittleEndian.Uint64(config[ptr+pciDSNSerialOffset:])
+	octets := make([]string, 8)
+	for i := range octets {
+		octets[i] = fmt.Sprintf("%02x", byte(serial>>(56-8*i)))
+	}
+	return strings.Join(octets, "-"), nil
+}
+
+// pcieActiveLanes returns the number of the width negotiated lanes that
+// haven't reported an error in laneErrors.
+func pcieActiveLanes(width uint64, laneErrors uint32) uint64 {
+	if width < 32 {
+		laneErrors &= 1<<width - 1
+	}
+	return width - uint64(bits.OnesCount32(laneErrors))
+}
+
+// decodeCompletionTimeout returns whether the completion timeout is disabled
+// and the encoded timeout range from a Device Control 2 register value.
+func decodeCompletionTimeout(devCtl2 uint16) (disabled bool, value uint8) {
+	return devCtl2&pciExpDevCtl2CTODisable != 0, uint8(devCtl2 & pciExpDevCtl2CTOValue)
+}
@@ -20,6 +20,12 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -28,16 +34,30 @@ import (
 
 const (
 	pcideviceSubsystem = "pcidevice"
+
+	// pciClassUnknown is the class of devices whose class attribute can't be
+	// read, exposed as class_id 0xffffff. It's not a valid class, base class
+	// 0xff with subclass and programming interface 0xff isn't assigned.
+	pciClassUnknown = 0xffffff
+
+	// Formats of the PCI ID labels, see --collector.pcidevice.id-format.
+	pciIDFormatHex0x = "hex0x"
+	pciIDFormatRaw   = "raw"
+
+	// pciLinkRereadDelay is how long --collector.pcidevice.link-reread waits
+	// before re-reading downgraded links. It's spent at most once per scrape.
+	pciLinkRereadDelay = 100 * time.Millisecond
 )
 
 var (
-	pciIdsPaths = []string{
-		"/usr/share/misc/pci.ids",
-		"/usr/share/hwdata/pci.ids",
-		"/var/lib/pciutils/pci.ids",
-	}
-	pciIdsFile = kingpin.Flag("collector.pcidevice.idsfile", "Path to pci.ids file to use for PCI device identification.").String()
-	pciNames   = kingpin.Flag("collector.pcidevice.names", "Enable PCI device name resolution (requires pci.ids file).").Default("false").Bool()
+	pciNames      = kingpin.Flag("collector.pcidevice.names", "Enable PCI device name resolution (requires pci.ids file).").Default("false").Bool()
+	pciNvmeInfo   = kingpin.Flag("collector.pcidevice.nvme-info", "Expose model and serial of NVMe controllers.").Default("false").Bool()
+	pciLinkReread = kingpin.Flag("collector.pcidevice.link-reread", "Re-read the link speed and width of devices whose link looks downgraded once after a short delay, to skip transient values while the link trains.").Default("false").Bool()
+	pciFirmware   = kingpin.Flag("collector.pcidevice.firmware-version", "Expose the firmware version reported by the driver of devices with a fw_version, NVMe firmware_rev or InfiniBand fw_ver attribute.").Default("false").Bool()
+	pciDevTimeout = kingpin.Flag("collector.pcidevice.device-timeout", "Maximum time to read the config space, AER statistics and other attributes of a single device. This trades completeness for liveness: a device that doesn't answer in time, e.g. a wedged one, is missing those metrics from the scrape instead of stalling it, and node_pcidevice_read_timeout_total is incremented. 0 disables the timeout.").Default("2s").Duration()
+	pciGenLabels  = kingpin.Flag("collector.pcidevice.gen-labels", "Add the PCIe generation of the current and maximum link speed as pcie_gen_current and pcie_gen_max labels to node_pcidevice_info.").Default("false").Bool()
+	pciIncludeVFs = kingpin.Flag("collector.pcidevice.include-vfs", "Expose SR-IOV virtual functions, the devices with a physfn link. Disable to only expose physical functions, node_pcidevice_info has an is_vf label either way.").Default("true").Bool()
+	pciIDFormat   = kingpin.Flag("collector.pcidevice.id-format", "Format of the class, vendor, device and revision ID labels: hex0x (0x10de) or raw (10de, as printed by lspci -n).").Default(pciIDFormatHex0x).Enum(pciIDFormatHex0x, pciIDFormatRaw)
 
 	pcideviceLabelNames = []string{"segment", "bus", "device", "function"}
 
@@ -50,6 +70,15 @@ var (
 		valueType: prometheus.GaugeValue,
 	}
 
+	pcideviceHwMaxLinkTSDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "hw_max_link_transfers_per_second"),
+			"Maximum link transfers per second (T/s) the hardware supports according to the PCIe Link Capabilities register, higher than max_link_transfers_per_second if firmware capped the link.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
 	pcideviceMaxLinkWidthDesc = typedDesc{
 		desc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, pcideviceSubsystem, "max_link_width"),
@@ -130,6 +159,321 @@ var (
 		valueType: prometheus.GaugeValue,
 	}
 
+	pcideviceSriovVfsBoundDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "sriov_vfs_bound"),
+			"Number of enabled Virtual Functions (VFs) bound to a driver, including vfio-pci for VFs assigned to VMs.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceAutosuspendDelayDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "autosuspend_delay_seconds"),
+			"Runtime PM autosuspend delay of the PCI device. -1 indicates autosuspend is disabled.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceResetMethodInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "reset_method_info"),
+			"Reset method supported by the PCI device according to reset_method, e.g. flr or bus, value is always 1.",
+			append(pcideviceLabelNames, "method"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceOperationalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "operational"),
+			"Whether the kernel still drives the PCI device (0/1). It's not operational if power/runtime_status is error or if enable dropped to 0 after the device was seen enabled, as when the kernel gives up on it after fatal AER errors.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceFirmwareInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "firmware_info"),
+			"ACPI path of the firmware node of the PCI device from firmware_node/path, value is always 1.",
+			append(pcideviceLabelNames, "firmware_path"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceAERErrorsDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "aer_errors_total"),
+			"Errors logged by the AER driver for the PCIe device per severity, for root and downstream ports without AER statistics of their own the errors reported to the port by the devices below it.",
+			append(pcideviceLabelNames, "severity"), nil,
+		),
+		valueType: prometheus.CounterValue,
+	}
+
+	pcideviceSurpriseRemovalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "surprise_removal_total"),
+			"Surprise removals below the PCI bridge: the Surprise Down errors logged by AER or, for bridges without AER statistics, the devices below it that disappeared between two scrapes.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.CounterValue,
+	}
+
+	pcideviceTopologyDepthDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "topology_depth"),
+			"Number of PCI bridges between the device and its root complex.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceActiveLanesDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "active_lanes"),
+			"Negotiated lanes not reporting errors in the PCIe Lane Error Status register, the current link width if it can't be read.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceASPMPolicyInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "aspm_policy_info"),
+			"Active PCIe ASPM policy of the device, the global pcie_aspm policy or disabled if the device's Link Control register has ASPM turned off. Value is always 1.",
+			append(pcideviceLabelNames, "policy"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceLinkRetrainDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "link_retrain_total"),
+			"Number of times the PCIe link was retrained, only available on platforms exposing link/retrain_count.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.CounterValue,
+	}
+
+	pcideviceReadTimeoutDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "read_timeout_total"),
+			"Scrapes in which reading the attributes of the PCI device took longer than --collector.pcidevice.device-timeout and its metrics were skipped.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.CounterValue,
+	}
+
+	pcideviceRdmaInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "rdma_info"),
+			"InfiniBand/RDMA device registered for the PCI device, value is always 1.",
+			append(pcideviceLabelNames, "ib_device"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceErrorStateDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "error_state"),
+			"Whether the device reports an error condition through power/runtime_status or broken_parity_status (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceNvmeInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "nvme_info"),
+			"Model and serial of the NVMe controller behind the PCI device, value is always 1.",
+			append(pcideviceLabelNames, "model", "serial"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceSerialInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "serial_info"),
+			"Serial number of the PCIe device from its Device Serial Number extended capability, value is always 1.",
+			append(pcideviceLabelNames, "serial"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceFirmwareVersionInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "firmware_version_info"),
+			"Firmware version reported by the driver of the PCI device, value is always 1.",
+			append(pcideviceLabelNames, "firmware_version"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceTransactionsPendingDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "transactions_pending"),
+			"Whether the PCIe device has issued non-posted requests that haven't completed yet, from the Transactions Pending bit of its Device Status register (0/1). A device stuck at 1 may be hung.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceMultifunctionDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "multifunction"),
+			"Whether the PCI device is part of a multifunction device, from the Multi-Function bit of its Header Type register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcidevicePMESupportedDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "pme_supported"),
+			"Whether the PCI device can signal a power management event (PME), e.g. wake-on-LAN, from the power state, from the PME_Support bits of its Power Management capability (0/1).",
+			append(pcideviceLabelNames, "state"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceExtendedTagEnabledDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "extended_tag_enabled"),
+			"Whether the PCIe device may use 8-bit tags, raising its outstanding requests from 32 to 256, from the Extended Tag Field Enable bit of its Device Control register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcidevice10BitTagEnabledDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "10bit_tag_enabled"),
+			"Whether the PCIe device may use 10-bit tags as a requester, allowing up to 768 outstanding requests, from the 10-Bit Tag Requester Enable bit of its Device Control 2 register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceSlotAttentionIndicatorDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "slot_attention_indicator"),
+			"State of the attention indicator (LED) of the slot below the PCIe port, from its Slot Control register. Value is 1 for the current state.",
+			append(pcideviceLabelNames, "state"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceSlotPowerIndicatorDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "slot_power_indicator"),
+			"State of the power indicator (LED) of the slot below the PCIe port, from its Slot Control register. Value is 1 for the current state.",
+			append(pcideviceLabelNames, "state"), nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceAtomicOpCompleterDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "atomicop_completer_supported"),
+			"Whether the PCIe device can complete 32 bit, 64 bit or 128 bit CAS AtomicOps according to its Device Capabilities 2 register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceAtomicOpRoutingDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "atomicop_routing_supported"),
+			"Whether the PCIe port routes AtomicOps between its other ports according to its Device Capabilities 2 register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceAtomicOpRequesterDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "atomicop_requester_enabled"),
+			"Whether the PCIe device is allowed to initiate AtomicOps according to its Device Control 2 register (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceCompletionTimeoutDisabledDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "completion_timeout_disabled"),
+			"Whether the PCIe completion timeout is disabled in Device Control 2 (0/1).",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceCompletionTimeoutValueDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "completion_timeout_value"),
+			"Encoded PCIe completion timeout range from Device Control 2, 0 is the default range of 50us to 50ms.",
+			pcideviceLabelNames, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceIdsSourceInfoDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "ids_source_info"),
+			"The pci.ids file used for name resolution, empty if none was loaded. Value is always 1.",
+			[]string{"path"}, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceIdsAgeSecondsDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "ids_age_seconds"),
+			"Age of the pci.ids file used for name resolution, from its modification time. Not reported for the embedded copy.",
+			nil, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceClassTotalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "class_total"),
+			"Number of PCI devices per base class, named when name resolution is enabled.",
+			[]string{"class_name"}, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceFunctionsTotalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "functions_total"),
+			"Number of PCI functions, including the virtual functions of SR-IOV devices.",
+			nil, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcidevicePhysicalTotalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "physical_total"),
+			"Number of PCI devices, counting the functions sharing a segment, bus and device once.",
+			nil, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
+	pcideviceLinkGenerationTotalDesc = typedDesc{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, pcideviceSubsystem, "link_generation_total"),
+			"Number of PCI devices per PCIe generation of their current link speed.",
+			[]string{"generation"}, nil,
+		),
+		valueType: prometheus.GaugeValue,
+	}
+
 	pcideviceNumaNodeDesc = typedDesc{
 		desc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, pcideviceSubsystem, "numa_node"),
@@ -146,6 +490,43 @@ type pcideviceCollector struct {
 	logger      *slog.Logger
 	pciProvider *pciIDProvider
 	pciNames    bool
+	nvmeInfo    bool
+	firmware    bool
+	idFormat    string
+	genLabels   bool
+	includeVFs  bool
+
+	// linkReread enables re-reading downgraded links after sleeping for
+	// linkRereadDelay.
+	linkReread      bool
+	linkRereadDelay time.Duration
+	sleep           func(time.Duration)
+
+	// deviceTimeout bounds the reads of updateDeviceDetails per device, 0
+	// for no limit.
+	deviceTimeout time.Duration
+
+	// The IDs, class and parent of a PCI device never change while it is
+	// present, so the full scan of /sys/bus/pci/devices is cached and only
+	// redone when the set of device locations changes. Link, power and SR-IOV
+	// state is re-read on every scrape. A device replaced at the same location
+	// between two scrapes is not noticed.
+	mu          sync.Mutex
+	devices     sysfs.PciDevices
+	deviceNames []string
+	// removals counts by parent location the devices that disappeared
+	// between two scrapes, the fallback for bridges without AER statistics.
+	removals map[string]float64
+	// vfs holds the cached devices that are SR-IOV virtual functions, which
+	// disappear when SR-IOV is disabled and don't count as removals.
+	vfs map[string]bool
+	// enabled holds the devices seen with enable set, to notice the kernel
+	// disabling them.
+	enabled map[string]bool
+	// timeouts counts the scrapes in which reading a device timed out.
+	timeouts map[sysfs.PciDeviceLocation]float64
+	// pending holds the devices whose timed out read is still running.
+	pending map[sysfs.PciDeviceLocation]bool
 }
 
 func init() {
@@ -160,21 +541,41 @@ func NewPcideviceCollector(logger *slog.Logger) (Collector, error) {
 	}
 
 	c := &pcideviceCollector{
-		fs:       fs,
-		logger:   logger,
-		pciNames: *pciNames,
+		fs:         fs,
+		logger:     logger,
+		pciNames:   *pciNames,
+		nvmeInfo:   *pciNvmeInfo,
+		firmware:   *pciFirmware,
+		idFormat:   *pciIDFormat,
+		genLabels:  *pciGenLabels,
+		includeVFs: *pciIncludeVFs,
+
+		linkReread:      *pciLinkReread,
+		linkRereadDelay: pciLinkRereadDelay,
+		sleep:           time.Sleep,
+
+		deviceTimeout: *pciDevTimeout,
+
+		removals: make(map[string]float64),
+		vfs:      make(map[string]bool),
+		enabled:  make(map[string]bool),
+		timeouts: make(map[sysfs.PciDeviceLocation]float64),
+		pending:  make(map[sysfs.PciDeviceLocation]bool),
 	}
 
 	// Build label names based on whether name resolution is enabled
 	labelNames := append(pcideviceLabelNames,
 		[]string{"parent_segment", "parent_bus", "parent_device", "parent_function",
-			"class_id", "vendor_id", "device_id", "subsystem_vendor_id", "subsystem_device_id", "revision"}...)
+			"class_id", "vendor_id", "device_id", "subsystem_vendor_id", "subsystem_device_id", "revision", "is_vf"}...)
 
 	if c.pciNames {
-		c.pciProvider = newPCIIDProvider(logger, pciIdsPaths, *pciIdsFile)
+		c.pciProvider = sharedPCIIDProvider(logger)
 		// Add name labels when name resolution is enabled
 		labelNames = append(labelNames, "vendor_name", "device_name", "subsystem_vendor_name", "subsystem_device_name", "class_name")
 	}
+	if c.genLabels {
+		labelNames = append(labelNames, "pcie_gen_current", "pcie_gen_max")
+	}
 
 	c.infoDesc = typedDesc{
 		desc: prometheus.NewDesc(
@@ -189,8 +590,236 @@ func NewPcideviceCollector(logger *slog.Logger) (Collector, error) {
 	return c, nil
 }
 
+// formatID renders a PCI ID label of the given number of hex digits in the
+// --collector.pcidevice.id-format.
+func (c *pcideviceCollector) formatID(id uint32, digits int) string {
+	if c.idFormat == pciIDFormatRaw {
+		return fmt.Sprintf("%0*x", digits, id)
+	}
+	return fmt.Sprintf("0x%0*x", digits, id)
+}
+
+// pciDevicePath returns the sysfs name of the PCI device at loc and its path
+// below bus/pci/devices. Location.String() separates the function with a
+// colon, sysfs uses a dot.
+func pciDevicePath(loc sysfs.PciDeviceLocation) (name, path string) {
+	name = fmt.Sprintf("%04x:%02x:%02x.%x", loc.Segment, loc.Bus, loc.Device, loc.Function)
+	return name, sysFilePath(filepath.Join("bus/pci/devices", name))
+}
+
+// pcieGenerationLabel returns the PCIe generation of a link speed in GT/s as
+// read from sysfs, empty if the speed is unknown.
+func pcieGenerationLabel(speed *float64) string {
+	if speed == nil {
+		return ""
+	}
+	if gen := pcieGeneration(*speed * 1e9); gen != "unknown" {
+		return gen
+	}
+	return ""
+}
+
+// pciLinkDowngraded reports whether the device's link runs below its maximum
+// speed or width.
+func pciLinkDowngraded(device sysfs.PciDevice) bool {
+	if device.CurrentLinkSpeed != nil && device.MaxLinkSpeed != nil && *device.CurrentLinkSpeed < *device.MaxLinkSpeed {
+		return true
+	}
+	return device.CurrentLinkWidth != nil && device.MaxLinkWidth != nil && *device.CurrentLinkWidth < *device.MaxLinkWidth
+}
+
+// downgradedLinks returns the sysfs names of the devices whose link looks
+// downgraded, after waiting linkRereadDelay for the links to settle if there
+// are any. The caller re-reads their link state.
+func (c *pcideviceCollector) downgradedLinks(devices sysfs.PciDevices, fresh bool) map[string]bool {
+	downgraded := make(map[string]bool)
+	for _, device := range devices {
+		name, devicePath := pciDevicePath(device.Location)
+		if !fresh {
+			readPCILinkState(&device, devicePath)
+		}
+		if pciLinkDowngraded(device) {
+			downgraded[name] = true
+		}
+	}
+	if len(downgraded) > 0 {
+		c.sleep(c.linkRereadDelay)
+	}
+	return downgraded
+}
+
+// pciDevices returns the PCI devices, rescanning them only if devices were
+// added or removed since the last call. fresh reports whether the dynamic
+// attributes of the returned devices are already up to date.
+func (c *pcideviceCollector) pciDevices() (devices sysfs.PciDevices, fresh bool, err error) {
+	entries, err := os.ReadDir(sysFilePath("bus/pci/devices"))
+	if err != nil {
+		return nil, false, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.devices != nil && slices.Equal(names, c.deviceNames) {
+		return c.devices, false, nil
+	}
+	devices, err = c.fs.PciDevices()
+	if err != nil {
+		// procfs fails the whole listing on a single device it can't read,
+		// e.g. one whose class attribute is unreadable. Read the devices one
+		// by one instead, so only those without vendor and device IDs are
+		// missing.
+		c.logger.Debug("Failed to read PCI devices, reading them one by one", "error", err)
+		devices = make(sysfs.PciDevices, len(names))
+		for _, name := range names {
+			device, err := readPCIDevice(name)
+			if err != nil {
+				c.logger.Debug("Failed to read PCI device", "device", name, "error", err)
+				continue
+			}
+			devices[name] = device
+		}
+	}
+	// Devices that are still listed but couldn't be read aren't removed.
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+	for _, device := range c.devices {
+		name, _ := pciDevicePath(device.Location)
+		if !listed[name] && !c.vfs[name] && device.ParentLocation != nil {
+			c.removals[device.ParentLocation.String()]++
+		}
+	}
+	clear(c.vfs)
+	for _, device := range devices {
+		if name, path := pciDevicePath(device.Location); isPCIVirtualFunction(path) {
+			c.vfs[name] = true
+		}
+	}
+	for name := range c.enabled {
+		if _, ok := devices[name]; !ok {
+			delete(c.enabled, name)
+		}
+	}
+	c.devices, c.deviceNames = devices, names
+	return devices, true, nil
+}
+
+// readPCIDevice reads the PCI device named name in bus/pci/devices. Unlike
+// procfs, only the vendor and device IDs are required: a class that can't be
+// read is reported as pciClassUnknown and other missing IDs as 0.
+func readPCIDevice(name string) (sysfs.PciDevice, error) {
+	var device sysfs.PciDevice
+	devicePath := sysFilePath(filepath.Join("bus/pci/devices", name))
+	target, err := os.Readlink(devicePath)
+	if err != nil {
+		return device, err
+	}
+	loc, err := parsePCIDeviceLocation(filepath.Base(target))
+	if err != nil {
+		return device, err
+	}
+	device.Location = loc
+	// Devices directly below a host bridge have a pciXXXX:XX parent.
+	if parent, err := parsePCIDeviceLocation(filepath.Base(filepath.Dir(target))); err == nil {
+		device.ParentLocation = &parent
+	}
+
+	readID := func(name string) (uint32, error) {
+		value, err := readSysfsFile(filepath.Join(devicePath, name))
+		if err != nil {
+			return 0, err
+		}
+		id, err := strconv.ParseUint(value, 0, 32)
+		return uint32(id), err
+	}
+	if device.Vendor, err = readID("vendor"); err != nil {
+		return device, err
+	}
+	if device.Device, err = readID("device"); err != nil {
+		return device, err
+	}
+	if device.Class, err = readID("class"); err != nil {
+		device.Class = pciClassUnknown
+	}
+	device.SubsystemVendor, _ = readID("subsystem_vendor")
+	device.SubsystemDevice, _ = readID("subsystem_device")
+	device.Revision, _ = readID("revision")
+
+	for _, attr := range []struct {
+		name  string
+		value **float64
+	}{
+		{"max_link_speed", &device.MaxLinkSpeed},
+		{"max_link_width", &device.MaxLinkWidth},
+	} {
+		value, err := readSysfsFile(filepath.Join(devicePath, attr.name))
+		if err != nil {
+			continue
+		}
+		// Speeds are e.g. "8.0 GT/s PCIe".
+		value, _, _ = strings.Cut(value, " ")
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			*attr.value = &v
+		}
+	}
+	if value, err := readSysfsFile(filepath.Join(devicePath, "numa_node")); err == nil {
+		if n, err := strconv.ParseInt(value, 10, 32); err == nil {
+			v := int32(n)
+			device.NumaNode = &v
+		}
+	}
+	refreshPcideviceState(&device, devicePath)
+	return device, nil
+}
+
+// parsePCIDeviceLocation parses a sysfs PCI device name such as 0000:00:02.1.
+func parsePCIDeviceLocation(name string) (sysfs.PciDeviceLocation, error) {
+	var loc sysfs.PciDeviceLocation
+	if _, err := fmt.Sscanf(name, "%x:%x:%x.%x", &loc.Segment, &loc.Bus, &loc.Device, &loc.Function); err != nil {
+		return loc, fmt.Errorf("invalid PCI device location %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// operational reports whether the kernel still drives the PCI device named
+// name at devicePath, see pcideviceOperationalDesc. ok is false if neither
+// enable nor power/runtime_status can be read.
+func (c *pcideviceCollector) operational(name, devicePath string) (operational, ok bool) {
+	operational = true
+	if status, err := readSysfsFile(filepath.Join(devicePath, "power", "runtime_status")); err == nil {
+		ok = true
+		if status == "error" {
+			operational = false
+		}
+	}
+	if enable, err := readSysfsFile(filepath.Join(devicePath, "enable")); err == nil {
+		ok = true
+		c.mu.Lock()
+		if enable != "0" {
+			c.enabled[name] = true
+		} else if c.enabled[name] {
+			operational = false
+		}
+		c.mu.Unlock()
+	}
+	return operational, ok
+}
+
+// surpriseRemovals returns the number of devices below the bridge at loc
+// that disappeared between two scrapes.
+func (c *pcideviceCollector) surpriseRemovals(loc sysfs.PciDeviceLocation) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removals[loc.String()]
+}
+
 func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
-	devices, err := c.fs.PciDevices()
+	devices, fresh, err := c.pciDevices()
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			c.logger.Debug("PCI device not found, skipping")
@@ -199,7 +828,54 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 		return fmt.Errorf("error obtaining PCI device info: %w", err)
 	}
 
+	if c.pciNames && c.pciProvider != nil {
+		ch <- pcideviceIdsSourceInfoDesc.mustNewConstMetric(1.0, c.pciProvider.getSource())
+		if age, ok := c.pciProvider.getAge(time.Now()); ok {
+			ch <- pcideviceIdsAgeSecondsDesc.mustNewConstMetric(age.Seconds())
+		}
+	}
+
+	// The ASPM policy is set globally, the per device Link Control register
+	// only tells whether ASPM ended up enabled on the link. Without the
+	// pcie_aspm module parameter, e.g. with ASPM support compiled out, no
+	// policy is reported.
+	var aspmPolicy string
+	if data, err := os.ReadFile(sysFilePath("module/pcie_aspm/parameters/policy")); err == nil {
+		if aspmPolicy, err = parsePCIeASPMPolicy(string(data)); err != nil {
+			c.logger.Debug("Failed to parse PCIe ASPM policy", "error", err)
+		}
+	}
+
+	var reread map[string]bool
+	if c.linkReread {
+		reread = c.downgradedLinks(devices, fresh)
+	}
+
+	classCounts := make(map[string]int)
+	generationCounts := make(map[string]int)
+	physical := make(map[sysfs.PciDeviceLocation]bool)
 	for _, device := range devices {
+		sysfsName, devicePath := pciDevicePath(device.Location)
+		isVF := isPCIVirtualFunction(devicePath)
+		if isVF && !c.includeVFs {
+			continue
+		}
+
+		baseClass := c.formatID(device.Class>>16, 2)
+		if c.pciNames && c.pciProvider != nil {
+			baseClass = c.pciProvider.getClassName(fmt.Sprintf("0x%02x", device.Class>>16))
+		}
+		classCounts[baseClass]++
+		physicalLoc := device.Location
+		physicalLoc.Function = 0
+		physical[physicalLoc] = true
+
+		if !fresh {
+			refreshPcideviceState(&device, devicePath)
+		} else if reread[sysfsName] {
+			readPCILinkState(&device, devicePath)
+		}
+
 		// The device location is represented in separated format.
 		values := device.Location.Strings()
 		if device.ParentLocation != nil {
@@ -209,16 +885,25 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 		}
 
 		// Add basic device information
-		classID := fmt.Sprintf("0x%06x", device.Class)
-		vendorID := fmt.Sprintf("0x%04x", device.Vendor)
-		deviceID := fmt.Sprintf("0x%04x", device.Device)
-		subsysVendorID := fmt.Sprintf("0x%04x", device.SubsystemVendor)
-		subsysDeviceID := fmt.Sprintf("0x%04x", device.SubsystemDevice)
+		values = append(values,
+			c.formatID(device.Class, 6),
+			c.formatID(device.Vendor, 4),
+			c.formatID(device.Device, 4),
+			c.formatID(device.SubsystemVendor, 4),
+			c.formatID(device.SubsystemDevice, 4),
+			c.formatID(device.Revision, 2),
+			strconv.FormatBool(isVF),
+		)
 
-		values = append(values, classID, vendorID, deviceID, subsysVendorID, subsysDeviceID, fmt.Sprintf("0x%02x", device.Revision))
-
-		// Add name values if name resolution is enabled
+		// Add name values if name resolution is enabled, pci.ids lookups
+		// always take the 0x prefixed IDs.
 		if c.pciNames && c.pciProvider != nil {
+			classID := fmt.Sprintf("0x%06x", device.Class)
+			vendorID := fmt.Sprintf("0x%04x", device.Vendor)
+			deviceID := fmt.Sprintf("0x%04x", device.Device)
+			subsysVendorID := fmt.Sprintf("0x%04x", device.SubsystemVendor)
+			subsysDeviceID := fmt.Sprintf("0x%04x", device.SubsystemDevice)
+
 			vendorName := c.pciProvider.getVendorName(vendorID)
 			deviceName := c.pciProvider.getDeviceName(vendorID, deviceID)
 			subsysVendorName := c.pciProvider.getVendorName(subsysVendorID)
@@ -227,6 +912,9 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 
 			values = append(values, vendorName, deviceName, subsysVendorName, subsysDeviceName, className)
 		}
+		if c.genLabels {
+			values = append(values, pcieGenerationLabel(device.CurrentLinkSpeed), pcieGenerationLabel(device.MaxLinkSpeed))
+		}
 
 		ch <- c.infoDesc.mustNewConstMetric(1.0, values...)
 
@@ -241,8 +929,10 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 		var currentLinkSpeedTS float64
 		if device.CurrentLinkSpeed != nil {
 			currentLinkSpeedTS = (*device.CurrentLinkSpeed) * 1e9
+			generationCounts[pcieGeneration(currentLinkSpeedTS)]++
 		} else {
 			currentLinkSpeedTS = -1
+			generationCounts["unknown"]++
 		}
 
 		// Get power state information directly from device object
@@ -287,14 +977,6 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 			sriovVfTotalMsix = float64(*device.SriovVfTotalMsix)
 		}
 
-		// Handle numa_node with nil safety
-		var numaNode float64
-		if device.NumaNode != nil {
-			numaNode = float64(*device.NumaNode)
-		} else {
-			numaNode = -1
-		}
-
 		// Handle link width fields with nil safety
 		var maxLinkWidth float64
 		if device.MaxLinkWidth != nil {
@@ -337,11 +1019,405 @@ func (c *pcideviceCollector) Update(ch chan<- prometheus.Metric) error {
 			}
 		}
 
-		// Only emit numa_node metric if the value is available (not -1)
-		if numaNode != -1 {
-			ch <- pcideviceNumaNodeDesc.mustNewConstMetric(numaNode, device.Location.Strings()...)
-		}
+		c.withDeviceTimeout(ch, device.Location, func(ch chan<- prometheus.Metric) {
+			c.updateDeviceDetails(ch, device, sysfsName, devicePath, aspmPolicy)
+		})
 	}
 
+	for class, count := range classCounts {
+		ch <- pcideviceClassTotalDesc.mustNewConstMetric(float64(count), class)
+	}
+	for generation, count := range generationCounts {
+		ch <- pcideviceLinkGenerationTotalDesc.mustNewConstMetric(float64(count), generation)
+	}
+	c.mu.Lock()
+	for loc, timeouts := range c.timeouts {
+		ch <- pcideviceReadTimeoutDesc.mustNewConstMetric(timeouts, loc.Strings()...)
+	}
+	c.mu.Unlock()
+	ch <- pcideviceFunctionsTotalDesc.mustNewConstMetric(float64(len(devices)))
+	ch <- pcidevicePhysicalTotalDesc.mustNewConstMetric(float64(len(physical)))
+
 	return nil
 }
+
+// updateDeviceDetails emits the metrics of the device that need further
+// reads of its sysfs directory and config space, which may block on a wedged
+// device.
+func (c *pcideviceCollector) updateDeviceDetails(ch chan<- prometheus.Metric, device sysfs.PciDevice, sysfsName, devicePath, aspmPolicy string) {
+	// Only physical functions have sriov_numvfs.
+	if device.SriovNumvfs != nil {
+		if bound, err := countBoundVFs(devicePath); err == nil {
+			ch <- pcideviceSriovVfsBoundDesc.mustNewConstMetric(float64(bound), device.Location.Strings()...)
+		}
+	}
+
+	// Only emit numa_node metric if the value is available (not -1)
+	if numaNode := readNumaNode(devicePath); numaNode != -1 {
+		ch <- pcideviceNumaNodeDesc.mustNewConstMetric(numaNode, device.Location.Strings()...)
+	}
+
+	depth, err := readPCITopologyDepth(devicePath)
+	if err != nil {
+		c.logger.Debug("Failed to read PCI topology depth", "device", sysfsName, "error", err)
+	} else {
+		ch <- pcideviceTopologyDepthDesc.mustNewConstMetric(float64(depth), device.Location.Strings()...)
+	}
+
+	// Upstream kernels don't count retrains, the attribute is only
+	// provided by some platform drivers.
+	if retrains, err := readUintFromFile(filepath.Join(devicePath, "link", "retrain_count")); err == nil {
+		ch <- pcideviceLinkRetrainDesc.mustNewConstMetric(float64(retrains), device.Location.Strings()...)
+	}
+
+	// Devices that don't use autosuspend fail the read with EIO.
+	if delay, err := readPCIAutosuspendDelay(devicePath); err == nil {
+		ch <- pcideviceAutosuspendDelayDesc.mustNewConstMetric(delay, device.Location.Strings()...)
+	}
+
+	if errorState, err := readPCIErrorState(devicePath); err == nil {
+		value := 0.0
+		if errorState {
+			value = 1
+		}
+		ch <- pcideviceErrorStateDesc.mustNewConstMetric(value, device.Location.Strings()...)
+	}
+
+	if operational, ok := c.operational(device.Name(), devicePath); ok {
+		value := 0.0
+		if operational {
+			value = 1
+		}
+		ch <- pcideviceOperationalDesc.mustNewConstMetric(value, device.Location.Strings()...)
+	}
+
+	if methods, err := readPCIResetMethods(devicePath); err == nil {
+		for _, method := range methods {
+			ch <- pcideviceResetMethodInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), method)...)
+		}
+	}
+
+	// Only devices described by ACPI have a firmware node with a path,
+	// device tree nodes have none.
+	if path, err := readSysfsFile(filepath.Join(devicePath, "firmware_node", "path")); err == nil && path != "" {
+		ch <- pcideviceFirmwareInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), path)...)
+	}
+
+	// Class 0x0600xx = host bridge, 0x0604xx = PCI bridge, including PCIe
+	// root and downstream ports, which aggregate the errors below them.
+	rootPort := device.Class>>8 == 0x0600 || device.Class>>8 == 0x0604
+	if aerErrors, err := readPCIeAERErrors(devicePath, rootPort); err == nil {
+		for _, s := range pcieAERSeverities {
+			if n, ok := aerErrors[s.severity]; ok {
+				ch <- pcideviceAERErrorsDesc.mustNewConstMetric(n, append(device.Location.Strings(), s.severity)...)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		c.logger.Debug("Failed to read AER statistics", "device", sysfsName, "error", err)
+	}
+
+	// Class 0x0604xx = PCI bridge, including PCIe root and downstream ports
+	if device.Class>>8 == 0x0604 {
+		removals, err := readPCIeSurpriseDownErrors(devicePath)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				c.logger.Debug("Failed to read AER statistics", "device", sysfsName, "error", err)
+			}
+			removals = c.surpriseRemovals(device.Location)
+		}
+		ch <- pcideviceSurpriseRemovalDesc.mustNewConstMetric(removals, device.Location.Strings()...)
+	}
+
+	// Unprivileged reads only return the first 64 bytes of the config
+	// space, which usually doesn't reach the PCIe capability.
+	config, _ := os.ReadFile(filepath.Join(devicePath, "config"))
+	if serial, err := parsePCIeDeviceSerialNumber(config); err == nil {
+		ch <- pcideviceSerialInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), serial)...)
+	}
+	if multifunction, err := parsePCIMultifunction(config); err == nil {
+		value := 0.0
+		if multifunction {
+			value = 1
+		}
+		ch <- pcideviceMultifunctionDesc.mustNewConstMetric(value, device.Location.Strings()...)
+	}
+	if supported, err := parsePCIPMESupport(config); err == nil {
+		for _, state := range pciPMEStates {
+			value := 0.0
+			if supported[state] {
+				value = 1
+			}
+			ch <- pcidevicePMESupportedDesc.mustNewConstMetric(value, append(device.Location.Strings(), state)...)
+		}
+	}
+	if devSta, err := parsePCIeDeviceStatus(config); err == nil {
+		pending := 0.0
+		if devSta&pciExpDevStaTrPnd != 0 {
+			pending = 1
+		}
+		ch <- pcideviceTransactionsPendingDesc.mustNewConstMetric(pending, device.Location.Strings()...)
+	}
+	if attention, power, err := parsePCIeSlotIndicators(config); err == nil {
+		for _, indicator := range []struct {
+			desc    typedDesc
+			current string
+		}{
+			{pcideviceSlotAttentionIndicatorDesc, attention},
+			{pcideviceSlotPowerIndicatorDesc, power},
+		} {
+			if indicator.current == "" {
+				continue
+			}
+			for _, state := range []string{"on", "blink", "off"} {
+				value := 0.0
+				if state == indicator.current {
+					value = 1
+				}
+				ch <- indicator.desc.mustNewConstMetric(value, append(device.Location.Strings(), state)...)
+			}
+		}
+	}
+	if devCtl, err := parsePCIeDeviceControl(config); err == nil {
+		extTag := 0.0
+		if devCtl&pciExpDevCtlExtTag != 0 {
+			extTag = 1
+		}
+		ch <- pcideviceExtendedTagEnabledDesc.mustNewConstMetric(extTag, device.Location.Strings()...)
+	}
+	if devCtl2, err := parsePCIeDeviceControl2(config); err == nil {
+		disabled, value := decodeCompletionTimeout(devCtl2)
+		disabledValue := 0.0
+		if disabled {
+			disabledValue = 1
+		}
+		ch <- pcideviceCompletionTimeoutDisabledDesc.mustNewConstMetric(disabledValue, device.Location.Strings()...)
+		ch <- pcideviceCompletionTimeoutValueDesc.mustNewConstMetric(float64(value), device.Location.Strings()...)
+
+		requester := 0.0
+		if devCtl2&pciExpDevCtl2AtomicReq != 0 {
+			requester = 1
+		}
+		ch <- pcideviceAtomicOpRequesterDesc.mustNewConstMetric(requester, device.Location.Strings()...)
+
+		tenBitTag := 0.0
+		if devCtl2&pciExpDevCtl2TenBitTag != 0 {
+			tenBitTag = 1
+		}
+		ch <- pcidevice10BitTagEnabledDesc.mustNewConstMetric(tenBitTag, device.Location.Strings()...)
+	}
+
+	if devCap2, err := parsePCIeDeviceCapabilities2(config); err == nil {
+		completer := 0.0
+		if devCap2&pciExpDevCap2AtomicComp != 0 {
+			completer = 1
+		}
+		ch <- pcideviceAtomicOpCompleterDesc.mustNewConstMetric(completer, device.Location.Strings()...)
+
+		// Only switch and root ports route AtomicOps.
+		if device.Class>>8 == 0x0604 {
+			routing := 0.0
+			if devCap2&pciExpDevCap2AtomicRout != 0 {
+				routing = 1
+			}
+			ch <- pcideviceAtomicOpRoutingDesc.mustNewConstMetric(routing, device.Location.Strings()...)
+		}
+	}
+
+	if hwMaxLinkSpeed, err := parsePCIeLinkCapabilitiesSpeed(config); err == nil {
+		ch <- pcideviceHwMaxLinkTSDesc.mustNewConstMetric(hwMaxLinkSpeed, device.Location.Strings()...)
+	}
+
+	// Link Control can only be read as root, fall back to the global
+	// policy without it.
+	if aspmPolicy != "" {
+		policy := aspmPolicy
+		if lnkCtl, err := parsePCIeLinkControl(config); err == nil && lnkCtl&pciExpLnkCtlASPM == 0 {
+			policy = "disabled"
+		}
+		ch <- pcideviceASPMPolicyInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), policy)...)
+	}
+
+	// Active lanes are best effort: lanes are only known to be bad from
+	// the Lane Error Status register, which needs root to read and isn't
+	// implemented by every device. Otherwise all negotiated lanes count.
+	if device.CurrentLinkWidth != nil {
+		lanes := uint64(*device.CurrentLinkWidth)
+		if laneErrors, err := parsePCIeLaneErrorStatus(config); err == nil {
+			lanes = pcieActiveLanes(lanes, laneErrors)
+		}
+		ch <- pcideviceActiveLanesDesc.mustNewConstMetric(float64(lanes), device.Location.Strings()...)
+	}
+
+	// RDMA capable devices, e.g. InfiniBand HCAs (class 0x0207xx) or
+	// RoCE NICs, register their IB devices in infiniband/.
+	if ibDevices, err := os.ReadDir(filepath.Join(devicePath, "infiniband")); err == nil {
+		for _, ibDevice := range ibDevices {
+			ch <- pcideviceRdmaInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), ibDevice.Name())...)
+		}
+	}
+
+	if c.firmware {
+		if version, err := readPCIFirmwareVersion(devicePath); err == nil {
+			ch <- pcideviceFirmwareVersionInfoDesc.mustNewConstMetric(1, append(device.Location.Strings(), version)...)
+		}
+	}
+
+	// Class 0x0108xx = Non-Volatile memory controller
+	if c.nvmeInfo && device.Class>>8 == 0x0108 {
+		c.updateNvmeInfo(ch, device.Location.Strings(), devicePath)
+	}
+}
+
+// withDeviceTimeout runs update, which reads the attributes of the device at
+// loc, bounded by c.deviceTimeout. Its metrics are only forwarded to ch if it
+// completes in time. Otherwise the device is counted in c.timeouts and update
+// is left to finish in the background, its metrics discarded. Until it does,
+// the device is counted as timed out again without starting another read, so
+// that a wedged device doesn't pile up a blocked goroutine per scrape.
+func (c *pcideviceCollector) withDeviceTimeout(ch chan<- prometheus.Metric, loc sysfs.PciDeviceLocation, update func(chan<- prometheus.Metric)) {
+	if c.deviceTimeout <= 0 {
+		update(ch)
+		return
+	}
+
+	c.mu.Lock()
+	if c.pending[loc] {
+		c.timeouts[loc]++
+		c.mu.Unlock()
+		c.logger.Debug("Previous read of PCI device still pending, skipping it", "device", loc.String())
+		return
+	}
+	c.pending[loc] = true
+	c.mu.Unlock()
+
+	result := make(chan []prometheus.Metric, 1)
+	go func() {
+		sink := make(chan prometheus.Metric)
+		go func() {
+			defer close(sink)
+			update(sink)
+		}()
+		var metrics []prometheus.Metric
+		for m := range sink {
+			metrics = append(metrics, m)
+		}
+		c.mu.Lock()
+		delete(c.pending, loc)
+		c.mu.Unlock()
+		result <- metrics
+	}()
+
+	timer := time.NewTimer(c.deviceTimeout)
+	defer timer.Stop()
+	select {
+	case metrics := <-result:
+		for _, m := range metrics {
+			ch <- m
+		}
+	case <-timer.C:
+		c.logger.Warn("Timed out reading PCI device, skipping it", "device", loc.String(), "timeout", c.deviceTimeout)
+		c.mu.Lock()
+		c.timeouts[loc]++
+		c.mu.Unlock()
+	}
+}
+
+// readPCILinkState re-reads the current link speed and width of a device.
+func readPCILinkState(device *sysfs.PciDevice, devicePath string) {
+	device.CurrentLinkSpeed = nil
+	if value, err := readSysfsFile(filepath.Join(devicePath, "current_link_speed")); err == nil {
+		// e.g. "8.0 GT/s PCIe"
+		if speed, unit, ok := strings.Cut(value, " "); ok && unit == "GT/s PCIe" {
+			if v, err := strconv.ParseFloat(speed, 64); err == nil {
+				device.CurrentLinkSpeed = &v
+			}
+		}
+	}
+
+	device.CurrentLinkWidth = nil
+	if value, err := readSysfsFile(filepath.Join(devicePath, "current_link_width")); err == nil {
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			v := float64(n)
+			device.CurrentLinkWidth = &v
+		}
+	}
+}
+
+// refreshPcideviceState re-reads the attributes of a cached device that may
+// change at runtime. Attributes that can't be read are reset to unknown.
+func refreshPcideviceState(device *sysfs.PciDevice, devicePath string) {
+	readFile := func(name string) (string, bool) {
+		value, err := readSysfsFile(filepath.Join(devicePath, name))
+		if err != nil || value == "" {
+			return "", false
+		}
+		return value, true
+	}
+	readUint := func(name string) (uint64, bool) {
+		value, ok := readFile(name)
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		return n, err == nil
+	}
+
+	readPCILinkState(device, devicePath)
+
+	device.PowerState = nil
+	if value, ok := readFile("power_state"); ok {
+		v := sysfs.PciPowerState(value)
+		device.PowerState = &v
+	}
+
+	device.D3coldAllowed = nil
+	if n, ok := readUint("d3cold_allowed"); ok {
+		v := n != 0
+		device.D3coldAllowed = &v
+	}
+
+	device.SriovDriversAutoprobe = nil
+	if n, ok := readUint("sriov_drivers_autoprobe"); ok {
+		v := n != 0
+		device.SriovDriversAutoprobe = &v
+	}
+
+	device.SriovNumvfs = nil
+	if n, ok := readUint("sriov_numvfs"); ok {
+		v := uint32(n)
+		device.SriovNumvfs = &v
+	}
+
+	device.SriovTotalvfs = nil
+	if n, ok := readUint("sriov_totalvfs"); ok {
+		v := uint32(n)
+		device.SriovTotalvfs = &v
+	}
+
+	device.SriovVfTotalMsix = nil
+	if n, ok := readUint("sriov_vf_total_msix"); ok {
+		device.SriovVfTotalMsix = &n
+	}
+}
+
+// updateNvmeInfo emits the NVMe identity of the controllers found in the
+// nvme/ directory of the PCI device at devicePath.
+func (c *pcideviceCollector) updateNvmeInfo(ch chan<- prometheus.Metric, labels []string, devicePath string) {
+	controllers, err := filepath.Glob(filepath.Join(devicePath, "nvme", "nvme*"))
+	if err != nil {
+		return
+	}
+	for _, controller := range controllers {
+		model, err := readSysfsFile(filepath.Join(controller, "model"))
+		if err != nil {
+			c.logger.Debug("Failed to read NVMe model", "path", controller, "error", err)
+			continue
+		}
+		serial, err := readSysfsFile(filepath.Join(controller, "serial"))
+		if err != nil {
+			c.logger.Debug("Failed to read NVMe serial", "path", controller, "error", err)
+			continue
+		}
+		ch <- pcideviceNvmeInfoDesc.mustNewConstMetric(1.0, append(labels, model, serial)...)
+	}
+}
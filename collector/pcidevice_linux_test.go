@@ -16,16 +16,23 @@
 package collector
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestPCICollectorWithNameResolution(t *testing.T) {
@@ -57,12 +64,40 @@ func TestPCICollectorWithNameResolution(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = testutil.GatherAndCompare(reg, strings.NewReader(string(expectedOutput)))
+	// The age of the pci.ids file depends on the checkout, it's covered by
+	// TestPCIIDProviderAge.
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := reg.Gather()
+		return slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+			return family.GetName() == "node_pcidevice_ids_age_seconds"
+		}), err
+	})
+	err = testutil.GatherAndCompare(gatherer, strings.NewReader(string(expectedOutput)))
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestSharedPCIIDProvider(t *testing.T) {
+	defer func(file string) { *pciIdsFile = file }(*pciIdsFile)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	*pciIdsFile = "fixtures/pci.ids"
+	p := sharedPCIIDProvider(logger)
+	if got := sharedPCIIDProvider(logger); got != p {
+		t.Error("expected the provider to be shared")
+	}
+	if got := p.getSource(); got != "fixtures/pci.ids" {
+		t.Errorf("got source %q, want fixtures/pci.ids", got)
+	}
+
+	// A different configuration loads a new provider.
+	*pciIdsFile = "/nonexistent/pci.ids"
+	if got := sharedPCIIDProvider(logger); got == p {
+		t.Error("expected a new provider after the configuration changed")
+	}
+}
+
 // testPCICollector wraps the PCI collector for testing
 type testPCICollector struct {
 	pc Collector
@@ -86,3 +121,1319 @@ func (tc *testPCICollector) Collect(ch chan<- prometheus.Metric) {
 func (tc *testPCICollector) Describe(ch chan<- *prometheus.Desc) {
 	// No-op for testing
 }
+
+func TestReadPCITopologyDepth(t *testing.T) {
+	for name, want := range map[string]int{
+		"0000:00:02.1": 0,
+		"0000:01:00.0": 1,
+		"0000:45:00.0": 1,
+		// Behind the root port 0000:40:01.3 and the switch port 0000:44:00.0.
+		"0000:46:00.0": 2,
+	} {
+		got, err := readPCITopologyDepth(filepath.Join("fixtures/sys/bus/pci/devices", name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: want depth %d, got %d", name, want, got)
+		}
+	}
+}
+
+func TestReadPCIErrorState(t *testing.T) {
+	for name, want := range map[string]bool{
+		"0000:01:00.0": false,
+		// power/runtime_status is "error".
+		"0000:46:00.0": true,
+	} {
+		got, err := readPCIErrorState(filepath.Join("fixtures/sys/bus/pci/devices", name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: want error state %t, got %t", name, want, got)
+		}
+	}
+
+	// No signal is available for the vfio bound GPU.
+	if _, err := readPCIErrorState("fixtures/sys/bus/pci/devices/0000:c1:00.0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestReadPCIResetMethods(t *testing.T) {
+	for path, want := range map[string][]string{
+		"fixtures/sys/bus/pci/devices/0000:45:00.0": {"flr", "bus"},
+		"fixtures/sys/bus/pci/devices/0000:00:02.1": {"pm"},
+	} {
+		got, err := readPCIResetMethods(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: want reset methods %q, got %q", path, want, got)
+		}
+	}
+	if _, err := readPCIResetMethods("fixtures/sys/bus/pci/devices/0000:83:00.0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want os.ErrNotExist without reset_method, got %v", err)
+	}
+}
+
+func TestReadNumaNode(t *testing.T) {
+	noAffinity := t.TempDir()
+	if err := os.WriteFile(filepath.Join(noAffinity, "numa_node"), []byte("-1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		devicePath string
+		want       float64
+	}{
+		{"present", "fixtures/sys/bus/pci/devices/0000:83:00.0", 1},
+		{"no affinity", noAffinity, -1},
+		{"missing", t.TempDir(), -1},
+	} {
+		if got := readNumaNode(tc.devicePath); got != tc.want {
+			t.Errorf("%s: want NUMA node %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestPCICollectorNvmeInfo(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", "fixtures/sys",
+		"--collector.pcidevice.nvme-info",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// 0000:46:00.0 is NVMe class as well but has no nvme controller child.
+	expected := `# HELP node_pcidevice_nvme_info Model and serial of the NVMe controller behind the PCI device, value is always 1.
+# TYPE node_pcidevice_nvme_info gauge
+node_pcidevice_nvme_info{bus="01",device="00",function="0",model="CT2000P3SSD8",segment="0000",serial="2328E6EDD8A7"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_nvme_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorClassTotal(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", "fixtures/sys",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// Without name resolution the classes are reported as hex base class.
+	expected := `# HELP node_pcidevice_class_total Number of PCI devices per base class, named when name resolution is enabled.
+# TYPE node_pcidevice_class_total gauge
+node_pcidevice_class_total{class_name="0x01"} 2
+node_pcidevice_class_total{class_name="0x02"} 1
+node_pcidevice_class_total{class_name="0x03"} 3
+node_pcidevice_class_total{class_name="0x06"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_class_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorIDFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format    string
+		info      map[string]string
+		baseClass string
+	}{
+		{
+			format: pciIDFormatHex0x,
+			info: map[string]string{
+				"class_id": "0x010802", "vendor_id": "0xc0a9", "device_id": "0x540a",
+				"subsystem_vendor_id": "0xc0a9", "subsystem_device_id": "0x5021", "revision": "0x01",
+			},
+			baseClass: "0x01",
+		},
+		{
+			format: pciIDFormatRaw,
+			info: map[string]string{
+				"class_id": "010802", "vendor_id": "c0a9", "device_id": "540a",
+				"subsystem_vendor_id": "c0a9", "subsystem_device_id": "5021", "revision": "01",
+			},
+			baseClass: "01",
+		},
+	} {
+		if _, err := kingpin.CommandLine.Parse([]string{
+			"--path.sysfs", "fixtures/sys",
+			"--collector.pcidevice.id-format", tc.format,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(&testPCICollector{pc: c})
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var foundInfo, foundClass bool
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				switch mf.GetName() {
+				case "node_pcidevice_info":
+					// 0000:01:00.0 is an NVMe controller.
+					if labels["bus"] != "01" {
+						continue
+					}
+					foundInfo = true
+					for name, want := range tc.info {
+						if got := labels[name]; got != want {
+							t.Errorf("%s: want %s=%q, got %q", tc.format, name, want, got)
+						}
+					}
+				case "node_pcidevice_class_total":
+					if labels["class_name"] == tc.baseClass {
+						foundClass = true
+					}
+				}
+			}
+		}
+		if !foundInfo {
+			t.Errorf("%s: no node_pcidevice_info for 0000:01:00.0", tc.format)
+		}
+		if !foundClass {
+			t.Errorf("%s: no node_pcidevice_class_total for class %q", tc.format, tc.baseClass)
+		}
+	}
+}
+
+func TestPCICollectorAutosuspendDelay(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", "fixtures/sys",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// 0000:00:02.1 and 0000:01:00.0 have a delay of 100ms and 2s, autosuspend
+	// is disabled for 0000:46:00.0 and the other devices have no
+	// power/autosuspend_delay_ms.
+	expected := `# HELP node_pcidevice_autosuspend_delay_seconds Runtime PM autosuspend delay of the PCI device. -1 indicates autosuspend is disabled.
+# TYPE node_pcidevice_autosuspend_delay_seconds gauge
+node_pcidevice_autosuspend_delay_seconds{bus="00",device="02",function="1",segment="0000"} 0.1
+node_pcidevice_autosuspend_delay_seconds{bus="01",device="00",function="0",segment="0000"} 2
+node_pcidevice_autosuspend_delay_seconds{bus="46",device="00",function="0",segment="0000"} -1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_autosuspend_delay_seconds"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorLinkGeneration(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", "fixtures/sys",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// The fixture devices run at 5 GT/s, 8 GT/s and 16 GT/s.
+	expected := `# HELP node_pcidevice_link_generation_total Number of PCI devices per PCIe generation of their current link speed.
+# TYPE node_pcidevice_link_generation_total gauge
+node_pcidevice_link_generation_total{generation="2"} 1
+node_pcidevice_link_generation_total{generation="3"} 3
+node_pcidevice_link_generation_total{generation="4"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_link_generation_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	for speed, want := range map[float64]string{
+		2.5e9: "1",
+		32e9:  "5",
+		64e9:  "6",
+		// Not a PCIe link speed.
+		3e9: "unknown",
+	} {
+		if got := pcieGeneration(speed); got != want {
+			t.Errorf("%v T/s: want generation %q, got %q", speed, want, got)
+		}
+	}
+}
+
+func TestParsePCIeDeviceControl2(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x40
+	// Power management capability pointing at the PCIe capability.
+	config[0x40], config[0x41] = 0x01, 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// Device Control 2: completion timeout disabled, range 16ms to 55ms.
+	config[0x60+0x28] = 0x15
+
+	devCtl2, err := parsePCIeDeviceControl2(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled, value := decodeCompletionTimeout(devCtl2)
+	if !disabled || value != 5 {
+		t.Errorf("got disabled=%v value=%d, want disabled=true value=5", disabled, value)
+	}
+
+	// Unprivileged readers only see the 64 byte header.
+	if _, err := parsePCIeDeviceControl2(config[:64]); err == nil {
+		t.Error("expected error for truncated config space")
+	}
+	config[0x06] = 0
+	if _, err := parsePCIeDeviceControl2(config); err == nil {
+		t.Error("expected error without capabilities list")
+	}
+}
+
+func TestParsePCIeTagEnables(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+
+	for _, tc := range []struct {
+		name           string
+		devCtl         uint16
+		devCtl2        uint16
+		extTag, tenBit bool
+	}{
+		// Max payload 256, max read request 512, relaxed ordering, extended
+		// tags and no snoop, as set up for a Gen4 NVMe drive.
+		{"gen4 nvme", 0x2930, 0x1000, true, true},
+		// Extended tags only, 10-bit tags left off by the firmware.
+		{"extended only", 0x2910, 0x0005, true, false},
+		// Both off, e.g. behind a bridge that can't handle them.
+		{"disabled", 0x2810, 0x0000, false, false},
+	} {
+		binary.LittleEndian.PutUint16(config[0x60+0x08:], tc.devCtl)
+		binary.LittleEndian.PutUint16(config[0x60+0x28:], tc.devCtl2)
+
+		devCtl, err := parsePCIeDeviceControl(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		devCtl2, err := parsePCIeDeviceControl2(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := devCtl&pciExpDevCtlExtTag != 0; got != tc.extTag {
+			t.Errorf("%s: got extended tag %v, want %v", tc.name, got, tc.extTag)
+		}
+		if got := devCtl2&pciExpDevCtl2TenBitTag != 0; got != tc.tenBit {
+			t.Errorf("%s: got 10-bit tag %v, want %v", tc.name, got, tc.tenBit)
+		}
+	}
+
+	if _, err := parsePCIeDeviceControl(config[:64]); err == nil {
+		t.Error("expected error for truncated config space")
+	}
+}
+
+func TestParsePCIeSlotIndicators(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// PCIe capability version 2, downstream port with a slot.
+	binary.LittleEndian.PutUint16(config[0x60+0x02:], 0x0162)
+	// Slot Capabilities: attention button, power controller, attention and
+	// power indicators, hot-plug surprise and capable.
+	binary.LittleEndian.PutUint16(config[0x60+0x14:], 0x007b)
+
+	for _, tc := range []struct {
+		name                     string
+		sltCtl                   uint16
+		wantAttention, wantPower string
+	}{
+		// Attention indicator off, power indicator on.
+		{"idle", 0x01c0, "off", "on"},
+		// Locating the slot: attention indicator blinking.
+		{"locate", 0x0180, "blink", "on"},
+		// Powering the slot up: power indicator blinking.
+		{"power up", 0x02c0, "off", "blink"},
+	} {
+		binary.LittleEndian.PutUint16(config[0x60+0x18:], tc.sltCtl)
+		attention, power, err := parsePCIeSlotIndicators(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attention != tc.wantAttention || power != tc.wantPower {
+			t.Errorf("%s: got attention %q power %q, want %q %q", tc.name, attention, power, tc.wantAttention, tc.wantPower)
+		}
+	}
+
+	// A slot without indicators.
+	binary.LittleEndian.PutUint16(config[0x60+0x14:], 0x0063)
+	if attention, power, err := parsePCIeSlotIndicators(config); err != nil || attention != "" || power != "" {
+		t.Errorf("got attention %q power %q err %v, want no indicators", attention, power, err)
+	}
+
+	// An endpoint has no slot.
+	binary.LittleEndian.PutUint16(config[0x60+0x02:], 0x0002)
+	if _, _, err := parsePCIeSlotIndicators(config); !errors.Is(err, errNoPCIeSlot) {
+		t.Errorf("got error %v, want %v", err, errNoPCIeSlot)
+	}
+}
+
+func TestParsePCIeDeviceStatus(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// Device Status: Transactions Pending, AUX Power Detected and
+	// Correctable Error Detected.
+	binary.LittleEndian.PutUint16(config[0x60+0x0a:], 0x0020|0x0010|0x0001)
+
+	devSta, err := parsePCIeDeviceStatus(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devSta&pciExpDevStaTrPnd == 0 {
+		t.Errorf("devSta %#04x: expected transactions pending", devSta)
+	}
+
+	binary.LittleEndian.PutUint16(config[0x60+0x0a:], 0x0010)
+	if devSta, _ = parsePCIeDeviceStatus(config); devSta&pciExpDevStaTrPnd != 0 {
+		t.Errorf("devSta %#04x: expected no transactions pending", devSta)
+	}
+
+	if _, err := parsePCIeDeviceStatus(config[:64]); err == nil {
+		t.Error("expected error for truncated config space")
+	}
+}
+
+func TestParsePCIMultifunction(t *testing.T) {
+	for _, tc := range []struct {
+		headerType byte
+		want       bool
+	}{
+		{0x80, true},  // Multifunction type 0 header.
+		{0x81, true},  // Multifunction bridge.
+		{0x00, false}, // Single function type 0 header.
+		{0x01, false}, // Single function bridge.
+	} {
+		config := make([]byte, 64)
+		config[0x0e] = tc.headerType
+		got, err := parsePCIMultifunction(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("header type %#x: got multifunction %v, want %v", tc.headerType, got, tc.want)
+		}
+	}
+
+	if _, err := parsePCIMultifunction(make([]byte, 8)); err == nil {
+		t.Error("expected error for short config space")
+	}
+}
+
+func TestParsePCIeDeviceSerialNumber(t *testing.T) {
+	config := make([]byte, 4096)
+	// Secondary PCI Express capability, then the DSN capability at 0x140.
+	binary.LittleEndian.PutUint32(config[0x100:], 0x14010019)
+	binary.LittleEndian.PutUint32(config[0x140:], 0x00010003)
+	binary.LittleEndian.PutUint64(config[0x144:], 0x0011223344556677)
+
+	got, err := parsePCIeDeviceSerialNumber(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "00-11-22-33-44-55-66-77"; got != want {
+		t.Errorf("got serial %q, want %q", got, want)
+	}
+
+	// Unprivileged reads stop at 64 bytes.
+	if _, err := parsePCIeDeviceSerialNumber(config[:64]); err == nil {
+		t.Error("expected error without extended config space")
+	}
+	binary.LittleEndian.PutUint32(config[0x100:], 0x00010019)
+	if _, err := parsePCIeDeviceSerialNumber(config); err == nil {
+		t.Error("expected error without DSN capability")
+	}
+}
+
+func TestParsePCIPMESupport(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x40
+	// Power management capability followed by the PCIe capability.
+	config[0x40], config[0x41] = 0x01, 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// PMC: version 3, PME from D0, D3hot and D3cold.
+	binary.LittleEndian.PutUint16(config[0x42:], 0xc803)
+
+	got, err := parsePCIPMESupport(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"d0": true, "d1": false, "d2": false, "d3hot": true, "d3cold": true}
+	if !maps.Equal(got, want) {
+		t.Errorf("got PME support %v, want %v", got, want)
+	}
+
+	// A PCIe capability alone isn't enough.
+	config[0x34] = 0x60
+	if _, err := parsePCIPMESupport(config); err == nil {
+		t.Error("expected error without power management capability")
+	}
+}
+
+func TestParsePCIeAtomicOps(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// Device Capabilities 2: AtomicOp routing, 32 bit and 64 bit completer.
+	binary.LittleEndian.PutUint32(config[0x60+0x24:], 0x00000040|0x00000080|0x00000100)
+	// Device Control 2: AtomicOp requester enable.
+	config[0x60+0x28] = 0x40
+
+	devCap2, err := parsePCIeDeviceCapabilities2(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devCap2&pciExpDevCap2AtomicComp == 0 {
+		t.Errorf("devCap2 %#04x: expected AtomicOp completer support", devCap2)
+	}
+	if devCap2&pciExpDevCap2AtomicRout == 0 {
+		t.Errorf("devCap2 %#04x: expected AtomicOp routing support", devCap2)
+	}
+	devCtl2, err := parsePCIeDeviceControl2(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devCtl2&pciExpDevCtl2AtomicReq == 0 {
+		t.Errorf("devCtl2 %#04x: expected AtomicOp requester enabled", devCtl2)
+	}
+
+	config[0x60+0x24], config[0x60+0x25] = 0, 0
+	config[0x60+0x28] = 0
+	devCap2, _ = parsePCIeDeviceCapabilities2(config)
+	devCtl2, _ = parsePCIeDeviceControl2(config)
+	if devCap2&pciExpDevCap2AtomicComp != 0 || devCtl2&pciExpDevCtl2AtomicReq != 0 {
+		t.Errorf("got devCap2 %#04x devCtl2 %#04x, want no AtomicOp support", devCap2, devCtl2)
+	}
+
+	if _, err := parsePCIeDeviceCapabilities2(config[:64]); err == nil {
+		t.Error("expected error for truncated config space")
+	}
+}
+
+func TestParsePCIeLinkCapabilitiesSpeed(t *testing.T) {
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x60
+	config[0x60], config[0x61] = 0x10, 0x00
+	// Link Capabilities of a Gen5 x16 device: Max Link Speed 5, Max Link
+	// Width 16, ASPM L1, port number 0x12.
+	binary.LittleEndian.PutUint32(config[0x60+0x0c:], 0x12000000|0x800|16<<4|0x5)
+
+	speed, err := parsePCIeLinkCapabilitiesSpeed(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if speed != 32e9 {
+		t.Errorf("got max link speed %v, want 32e9", speed)
+	}
+
+	// Reserved encoding.
+	config[0x60+0x0c] = 0xf0
+	if _, err := parsePCIeLinkCapabilitiesSpeed(config); err == nil {
+		t.Error("expected error for reserved max link speed")
+	}
+	if _, err := parsePCIeLinkCapabilitiesSpeed(config[:64]); err == nil {
+		t.Error("expected error for truncated config space")
+	}
+}
+
+func TestParsePCIeASPMPolicy(t *testing.T) {
+	for data, want := range map[string]string{
+		"[default] performance powersave powersupersave\n": "default",
+		"default performance [powersave] powersupersave\n": "powersave",
+		"default performance powersave [powersupersave]":   "powersupersave",
+	} {
+		got, err := parsePCIeASPMPolicy(data)
+		if err != nil {
+			t.Fatalf("%q: %v", data, err)
+		}
+		if got != want {
+			t.Errorf("%q: got policy %q, want %q", data, got, want)
+		}
+	}
+	for _, data := range []string{"", "default performance", "[] default"} {
+		if _, err := parsePCIeASPMPolicy(data); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+
+	config := make([]byte, 256)
+	config[0x06] = 0x10 // Status: capabilities list
+	config[0x34] = 0x40
+	config[0x40], config[0x41] = 0x10, 0x00
+	// Link Control: ASPM L1 enabled, common clock configuration.
+	config[0x40+0x10] = 0x42
+	lnkCtl, err := parsePCIeLinkControl(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lnkCtl & pciExpLnkCtlASPM; got != 0x2 {
+		t.Errorf("got ASPM control %#x, want 0x2", got)
+	}
+}
+
+// writeTestPCIDevice creates a minimal PCI device below sysfs and links it
+// into bus/pci/devices.
+func writeTestPCIDevice(t testing.TB, sysfs, name, powerState string) {
+	t.Helper()
+	dir := filepath.Join(sysfs, "devices", "pci0000:00", name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, value := range map[string]string{
+		"class":            "0x020000",
+		"vendor":           "0x8086",
+		"device":           "0x1521",
+		"subsystem_vendor": "0x8086",
+		"subsystem_device": "0x00a3",
+		"revision":         "0x01",
+		"power_state":      powerState,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(sysfs, "bus", "pci", "devices", name)
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(link); err == nil {
+		return
+	}
+	if err := os.Symlink(filepath.Join("..", "..", "..", "devices", "pci0000:00", name), link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorDeviceCache(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	if got, err := testutil.GatherAndCount(reg, "node_pcidevice_info"); err != nil || got != 2 {
+		t.Fatalf("got %d devices (err %v), want 2", got, err)
+	}
+
+	// Cached devices still pick up runtime state changes.
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D3hot")
+	expected := `# HELP node_pcidevice_power_state PCIe device power state, one of: D0, D1, D2, D3hot, D3cold, unknown or error.
+# TYPE node_pcidevice_power_state gauge
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="D0"} 1
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="D1"} 0
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="D2"} 0
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="D3cold"} 0
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="D3hot"} 0
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="error"} 0
+node_pcidevice_power_state{bus="00",device="01",function="0",segment="0000",state="unknown"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="D0"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="D1"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="D2"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="D3cold"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="D3hot"} 1
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="error"} 0
+node_pcidevice_power_state{bus="00",device="02",function="0",segment="0000",state="unknown"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_power_state"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding and removing devices invalidates the cache.
+	writeTestPCIDevice(t, sysfs, "0000:00:03.0", "D0")
+	if got, err := testutil.GatherAndCount(reg, "node_pcidevice_info"); err != nil || got != 3 {
+		t.Fatalf("got %d devices (err %v) after adding one, want 3", got, err)
+	}
+	if err := os.Remove(filepath.Join(sysfs, "bus", "pci", "devices", "0000:00:01.0")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := testutil.GatherAndCount(reg, "node_pcidevice_info"); err != nil || got != 2 {
+		t.Fatalf("got %d devices (err %v) after removing one, want 2", got, err)
+	}
+}
+
+func TestPCICollectorActiveLanes(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	for _, name := range []string{"0000:00:01.0", "0000:00:02.0"} {
+		if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", name, "current_link_width"), []byte("8\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Full config space as read by root, with an AER capability followed by
+	// a Secondary PCI Express capability reporting errors on lanes 2 and 5.
+	// Bit 9 is beyond the negotiated width and ignored.
+	config := make([]byte, 4096)
+	config[0x100], config[0x101], config[0x103] = 0x01, 0x00, 0x14 // next at 0x140
+	config[0x140], config[0x141] = 0x19, 0x00
+	config[0x148], config[0x149] = 0x24, 0x02
+	if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0", "config"), config, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Unprivileged read, only the standard header is available.
+	if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:02.0", "config"), config[:64], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := `# HELP node_pcidevice_active_lanes Negotiated lanes not reporting errors in the PCIe Lane Error Status register, the current link width if it can't be read.
+# TYPE node_pcidevice_active_lanes gauge
+node_pcidevice_active_lanes{bus="00",device="01",function="0",segment="0000"} 6
+node_pcidevice_active_lanes{bus="00",device="02",function="0",segment="0000"} 8
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_active_lanes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorSriovVfsBound(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	pf := filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0")
+	if err := os.WriteFile(filepath.Join(pf, "sriov_numvfs"), []byte("4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// VFs 0 and 1 are bound to iavf and vfio-pci, 2 and 3 to no driver.
+	drivers := []string{"iavf", "vfio-pci", "", ""}
+	for i, driver := range drivers {
+		name := fmt.Sprintf("0000:00:01.%d", i+1)
+		writeTestPCIDevice(t, sysfs, name, "D0")
+		if err := os.Symlink(filepath.Join("..", name), filepath.Join(pf, fmt.Sprintf("virtfn%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if driver == "" {
+			continue
+		}
+		driverPath := filepath.Join(sysfs, "bus", "pci", "drivers", driver)
+		if err := os.MkdirAll(driverPath, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(driverPath, filepath.Join(sysfs, "devices", "pci0000:00", name, "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not a physical function.
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := `# HELP node_pcidevice_sriov_vfs_bound Number of enabled Virtual Functions (VFs) bound to a driver, including vfio-pci for VFs assigned to VMs.
+# TYPE node_pcidevice_sriov_vfs_bound gauge
+node_pcidevice_sriov_vfs_bound{bus="00",device="01",function="0",segment="0000"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_sriov_vfs_bound"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorLinkReread(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	for _, name := range []string{"0000:00:01.0", "0000:00:02.0"} {
+		for file, value := range map[string]string{
+			"max_link_speed":     "16.0 GT/s PCIe",
+			"max_link_width":     "16",
+			"current_link_speed": "16.0 GT/s PCIe",
+			"current_link_width": "16",
+		} {
+			if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", name, file), []byte(value+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	speedFile := filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0", "current_link_speed")
+	setSpeed := func(speed string) {
+		t.Helper()
+		if err := os.WriteFile(speedFile, []byte(speed+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", sysfs,
+		"--collector.pcidevice.link-reread",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The link of 0000:00:01.0 is still training when first read and
+	// reaches full speed during the delay.
+	var sleeps int
+	c.(*pcideviceCollector).sleep = func(time.Duration) {
+		sleeps++
+		setSpeed("16.0 GT/s PCIe")
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := `# HELP node_pcidevice_current_link_transfers_per_second Value of current link's transfers per second (T/s)
+# TYPE node_pcidevice_current_link_transfers_per_second gauge
+node_pcidevice_current_link_transfers_per_second{bus="00",device="01",function="0",segment="0000"} 1.6e+10
+node_pcidevice_current_link_transfers_per_second{bus="00",device="02",function="0",segment="0000"} 1.6e+10
+`
+	// The first scrape uses the freshly scanned devices, the second the
+	// cached ones.
+	for scrape := 1; scrape <= 2; scrape++ {
+		setSpeed("2.5 GT/s PCIe")
+		sleeps = 0
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_current_link_transfers_per_second"); err != nil {
+			t.Fatalf("scrape %d: %v", scrape, err)
+		}
+		if sleeps != 1 {
+			t.Errorf("scrape %d: slept %d times, want 1", scrape, sleeps)
+		}
+	}
+
+	// Links at full speed are not re-read.
+	sleeps = 0
+	if _, err := testutil.GatherAndCount(reg, "node_pcidevice_current_link_transfers_per_second"); err != nil {
+		t.Fatal(err)
+	}
+	if sleeps != 0 {
+		t.Errorf("slept %d times without downgraded links, want 0", sleeps)
+	}
+}
+
+func TestPCICollectorSurpriseRemoval(t *testing.T) {
+	sysfs := t.TempDir()
+	// 0000:00:01.0 is a downstream port without AER statistics,
+	// 0000:00:02.0 one whose AER driver logged 3 Surprise Down errors.
+	for _, name := range []string{"0000:00:01.0", "0000:00:02.0"} {
+		writeTestPCIDevice(t, sysfs, name, "D0")
+		if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", name, "class"), []byte("0x060400\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:02.0", "aer_dev_fatal"),
+		[]byte("Undefined 0\nDLP 0\nSDES 3\nTLP 0\nTOTAL_ERR_FATAL 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// An NVMe drive below 0000:00:01.0 and an SR-IOV virtual function of it.
+	port := filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0")
+	writeChild := func(name string) string {
+		t.Helper()
+		child := filepath.Join(port, name)
+		if err := os.Mkdir(child, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for file, value := range map[string]string{"class": "0x010802", "vendor": "0x144d", "device": "0xa80a", "subsystem_vendor": "0x144d", "subsystem_device": "0xa801", "revision": "0x00"} {
+			if err := os.WriteFile(filepath.Join(child, file), []byte(value+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(filepath.Join("..", "..", "..", "devices", "pci0000:00", "0000:00:01.0", name), filepath.Join(sysfs, "bus", "pci", "devices", name)); err != nil {
+			t.Fatal(err)
+		}
+		return child
+	}
+	child := writeChild("0000:01:00.0")
+	childLink := filepath.Join(sysfs, "bus", "pci", "devices", "0000:01:00.0")
+	vf := writeChild("0000:01:00.1")
+	vfLink := filepath.Join(sysfs, "bus", "pci", "devices", "0000:01:00.1")
+	if err := os.Symlink(filepath.Join("..", "0000:01:00.0"), filepath.Join(vf, "physfn")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := func(removed int) string {
+		return fmt.Sprintf(`# HELP node_pcidevice_surprise_removal_total Surprise removals below the PCI bridge: the Surprise Down errors logged by AER or, for bridges without AER statistics, the devices below it that disappeared between two scrapes.
+# TYPE node_pcidevice_surprise_removal_total counter
+node_pcidevice_surprise_removal_total{bus="00",device="01",function="0",segment="0000"} %d
+node_pcidevice_surprise_removal_total{bus="00",device="02",function="0",segment="0000"} 3
+`, removed)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(0)), "node_pcidevice_surprise_removal_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transient read error of the drive, while a new device shows up,
+	// isn't a removal.
+	vendor := filepath.Join(child, "vendor")
+	if err := os.Remove(vendor); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPCIDevice(t, sysfs, "0000:00:03.0", "D0")
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(0)), "node_pcidevice_surprise_removal_total"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(vendor, []byte("0x144d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Disabling SR-IOV removes the virtual function.
+	if err := os.Remove(vfLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(vf); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(0)), "node_pcidevice_surprise_removal_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The NVMe drive is pulled before the next scrape.
+	if err := os.Remove(childLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(child); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(1)), "node_pcidevice_surprise_removal_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorOperational(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	// Never enabled, e.g. no driver bound.
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	enable := func(name, value string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", name, "enable"), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enable("0000:00:01.0", "1")
+	enable("0000:00:02.0", "0")
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := func(operational int) string {
+		return fmt.Sprintf(`# HELP node_pcidevice_operational Whether the kernel still drives the PCI device (0/1). It's not operational if power/runtime_status is error or if enable dropped to 0 after the device was seen enabled, as when the kernel gives up on it after fatal AER errors.
+# TYPE node_pcidevice_operational gauge
+node_pcidevice_operational{bus="00",device="01",function="0",segment="0000"} %d
+node_pcidevice_operational{bus="00",device="02",function="0",segment="0000"} 1
+`, operational)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(1)), "node_pcidevice_operational"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kernel disables the device after fatal errors.
+	enable("0000:00:01.0", "0")
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected(0)), "node_pcidevice_operational"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorPhysicalTotal(t *testing.T) {
+	sysfs := t.TempDir()
+	// A dual port NIC with two functions and a single function device.
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:01.1", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := `# HELP node_pcidevice_functions_total Number of PCI functions, including the virtual functions of SR-IOV devices.
+# TYPE node_pcidevice_functions_total gauge
+node_pcidevice_functions_total 3
+# HELP node_pcidevice_physical_total Number of PCI devices, counting the functions sharing a segment, bus and device once.
+# TYPE node_pcidevice_physical_total gauge
+node_pcidevice_physical_total 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_functions_total", "node_pcidevice_physical_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorGenLabels(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	// No link speed, e.g. a root complex integrated endpoint.
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	for file, value := range map[string]string{
+		"current_link_speed": "8.0 GT/s PCIe",
+		"max_link_speed":     "16.0 GT/s PCIe",
+	} {
+		if err := os.WriteFile(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0", file), []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs, "--collector.pcidevice.gen-labels"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	expected := `# HELP node_pcidevice_info Non-numeric data from /sys/bus/pci/devices/<location>, value is always 1.
+# TYPE node_pcidevice_info gauge
+node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",pcie_gen_current="3",pcie_gen_max="4",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+node_pcidevice_info{bus="00",class_id="0x020000",device="02",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",pcie_gen_current="",pcie_gen_max="",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorUnreadableClass(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	if err := os.Remove(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:02.0", "class")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// Both devices are listed, the one without class with the unknown class.
+	expected := `# HELP node_pcidevice_info Non-numeric data from /sys/bus/pci/devices/<location>, value is always 1.
+# TYPE node_pcidevice_info gauge
+node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+node_pcidevice_info{bus="00",class_id="0xffffff",device="02",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorAERErrors(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", "fixtures/sys"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// The root port 0000:00:02.1 only has the aer_rootport_total_err_*
+	// files, the endpoints their aer_dev_* files.
+	expected := `# HELP node_pcidevice_aer_errors_total Errors logged by the AER driver for the PCIe device per severity, for root and downstream ports without AER statistics of their own the errors reported to the port by the devices below it.
+# TYPE node_pcidevice_aer_errors_total counter
+node_pcidevice_aer_errors_total{bus="00",device="02",function="1",segment="0000",severity="correctable"} 7
+node_pcidevice_aer_errors_total{bus="00",device="02",function="1",segment="0000",severity="fatal"} 0
+node_pcidevice_aer_errors_total{bus="00",device="02",function="1",segment="0000",severity="nonfatal"} 1
+node_pcidevice_aer_errors_total{bus="01",device="00",function="0",segment="0000",severity="correctable"} 0
+node_pcidevice_aer_errors_total{bus="01",device="00",function="0",segment="0000",severity="fatal"} 0
+node_pcidevice_aer_errors_total{bus="01",device="00",function="0",segment="0000",severity="nonfatal"} 0
+node_pcidevice_aer_errors_total{bus="45",device="00",function="0",segment="0000",severity="correctable"} 0
+node_pcidevice_aer_errors_total{bus="45",device="00",function="0",segment="0000",severity="fatal"} 0
+node_pcidevice_aer_errors_total{bus="45",device="00",function="0",segment="0000",severity="nonfatal"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_aer_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorIncludeVFs(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	pf := filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0")
+	for i, name := range []string{"0000:00:01.1", "0000:00:01.2"} {
+		writeTestPCIDevice(t, sysfs, name, "D0")
+		if err := os.Symlink(filepath.Join("..", name), filepath.Join(pf, fmt.Sprintf("virtfn%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..", "0000:00:01.0"), filepath.Join(sysfs, "devices", "pci0000:00", name, "physfn")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name: "default",
+			expected: `node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="1",is_vf="true",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="2",is_vf="true",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+`,
+		},
+		{
+			name: "physical functions only",
+			args: []string{"--no-collector.pcidevice.include-vfs"},
+			expected: `node_pcidevice_info{bus="00",class_id="0x020000",device="01",device_id="0x1521",function="0",is_vf="false",parent_bus="*",parent_device="*",parent_function="*",parent_segment="*",revision="0x01",segment="0000",subsystem_device_id="0x00a3",subsystem_vendor_id="0x8086",vendor_id="0x8086"} 1
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := kingpin.CommandLine.Parse(append([]string{"--path.sysfs", sysfs}, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(&testPCICollector{pc: c})
+
+			expected := `# HELP node_pcidevice_info Non-numeric data from /sys/bus/pci/devices/<location>, value is always 1.
+# TYPE node_pcidevice_info gauge
+` + tc.expected
+			if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_info"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPCICollectorFirmwareVersion(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", "fixtures/sys",
+		"--collector.pcidevice.firmware-version",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// 0000:01:00.0 is an NVMe controller with a firmware_rev, 0000:45:00.0
+	// an igb NIC with a fw_version attribute.
+	expected := `# HELP node_pcidevice_firmware_version_info Firmware version reported by the driver of the PCI device, value is always 1.
+# TYPE node_pcidevice_firmware_version_info gauge
+node_pcidevice_firmware_version_info{bus="01",device="00",firmware_version="P9CR30A",function="0",segment="0000"} 1
+node_pcidevice_firmware_version_info{bus="45",device="00",firmware_version="1.63, 0x800009fa",function="0",segment="0000"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_firmware_version_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorDeviceTimeout(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.sysfs", sysfs,
+		"--collector.pcidevice.device-timeout", "20ms",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	collector, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := collector.(*pcideviceCollector)
+
+	loc, err := parsePCIDeviceLocation("0000:00:01.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric := pcideviceNumaNodeDesc.mustNewConstMetric(0, loc.Strings()...)
+
+	// A read blocking past the timeout, as on a wedged device.
+	release := make(chan struct{})
+	done := make(chan struct{})
+	ch := make(chan prometheus.Metric, 1)
+	c.withDeviceTimeout(ch, loc, func(ch chan<- prometheus.Metric) {
+		defer close(done)
+		<-release
+		ch <- metric
+	})
+	if len(ch) != 0 {
+		t.Error("got metrics of the timed out device")
+	}
+
+	// The next scrape doesn't start another read while the first one is
+	// still blocked.
+	c.withDeviceTimeout(ch, loc, func(ch chan<- prometheus.Metric) {
+		t.Error("read the device again while the previous read was pending")
+	})
+
+	// Once the blocked read returns, the device is read again.
+	close(release)
+	<-done
+	for {
+		c.mu.Lock()
+		pending := c.pending[loc]
+		c.mu.Unlock()
+		if !pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.withDeviceTimeout(ch, loc, func(ch chan<- prometheus.Metric) {
+		time.Sleep(time.Millisecond)
+		ch <- metric
+	})
+	if len(ch) != 1 {
+		t.Errorf("got %d metrics, want 1 from the device answering in time", len(ch))
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+	expected := `# HELP node_pcidevice_read_timeout_total Scrapes in which reading the attributes of the PCI device took longer than --collector.pcidevice.device-timeout and its metrics were skipped.
+# TYPE node_pcidevice_read_timeout_total counter
+node_pcidevice_read_timeout_total{bus="00",device="01",function="0",segment="0000"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_read_timeout_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCICollectorRdmaInfo(t *testing.T) {
+	sysfs := t.TempDir()
+	writeTestPCIDevice(t, sysfs, "0000:00:01.0", "D0")
+	writeTestPCIDevice(t, sysfs, "0000:00:02.0", "D0")
+	if err := os.MkdirAll(filepath.Join(sysfs, "devices", "pci0000:00", "0000:00:01.0", "infiniband", "mlx5_0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", sysfs}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testPCICollector{pc: c})
+
+	// 0000:00:02.0 has no infiniband/ directory.
+	expected := `# HELP node_pcidevice_rdma_info InfiniBand/RDMA device registered for the PCI device, value is always 1.
+# TYPE node_pcidevice_rdma_info gauge
+node_pcidevice_rdma_info{bus="00",device="01",function="0",ib_device="mlx5_0",segment="0000"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_pcidevice_rdma_info"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkPCICollectorUpdate(b *testing.B) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.sysfs", "fixtures/sys"}); err != nil {
+		b.Fatal(err)
+	}
+	c, err := NewPcideviceCollector(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for range ch {
+		}
+	}()
+	defer close(ch)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := c.Update(ch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && !nothermalzone
+
+package collector
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type testThermalZoneCollector struct {
+	tc Collector
+}
+
+func (c testThermalZoneCollector) Collect(ch chan<- prometheus.Metric) {
+	c.tc.Update(ch)
+}
+
+func (c testThermalZoneCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func TestThermalZoneTemp(t *testing.T) {
+	// thermal_zone2 has no temp file and is skipped.
+	expected := `# HELP node_thermal_zone_temp Zone temperature in Celsius
+# TYPE node_thermal_zone_temp gauge
+node_thermal_zone_temp{type="cpu-thermal",zone="0"} 12.376
+node_thermal_zone_temp{type="x86_pkg_temp",zone="1"} 45.5
+`
+	*sysPath = "fixtures/sys"
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c, err := NewThermalZoneCollector(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(&testThermalZoneCollector{tc: c})
+
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "node_thermal_zone_temp"); err != nil {
+		t.Fatal(err)
+	}
+}
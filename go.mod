@@ -3,6 +3,7 @@ module github.com/prometheus/node_exporter
 go 1.24.0
 
 require (
+	github.com/NVIDIA/go-nvml v0.13.0-1
 	github.com/alecthomas/kingpin/v2 v2.4.0
 	github.com/beevik/ntp v1.5.0
 	github.com/coreos/go-systemd/v22 v22.6.0
@@ -27,6 +28,7 @@ require (
 	github.com/prometheus/exporter-toolkit v0.15.0
 	github.com/prometheus/procfs v0.19.2
 	github.com/safchain/ethtool v0.6.2
+	go.yaml.in/yaml/v2 v2.4.3
 	golang.org/x/exp v0.0.0-20250911091902-df9299821621
 	golang.org/x/sys v0.38.0
 	howett.net/plist v1.0.2-0.20250314012144-ee69052608d9
@@ -56,7 +58,6 @@ require (
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	golang.org/x/crypto v0.45.0 // indirect
 	golang.org/x/net v0.47.0 // indirect
 	golang.org/x/oauth2 v0.32.0 // indirect